@@ -17,6 +17,7 @@ func newFuzzer(c *cli.Context) error {
 	fuzzerName := c.Args().Get(0)
 	dir := c.Args().Get(1)
 	language := c.String("lang")
+	engine := c.String("engine")
 	base := c.String("base")
 
 	// Verify inputs
@@ -35,12 +36,15 @@ func newFuzzer(c *cli.Context) error {
 	if !utils.SupportedBase(base) {
 		return fmt.Errorf("base %s not supported", base)
 	}
+	if engine == "" {
+		engine = utils.DefaultEngine(language)
+	}
 
 	log.Println(fmt.Sprintf("Creating new fuzzer in %s", dir))
 	// Setup templates
 	log.Println("Templating...")
 	f := BlankFuzzer{}
-	template, err := templates.New(fuzzerName, language, c.Bool("asan"), base)
+	template, err := templates.New(fuzzerName, language, engine, c.Bool("asan"), base)
 	if err != nil {
 		return err
 	}
@@ -86,6 +90,10 @@ func main() {
 					Value: "c",
 					Usage: "programming language",
 				},
+				cli.StringFlag{
+					Name:  "engine",
+					Usage: "fuzzing engine (afl, libfuzzer or go-fuzz), defaults to the language's usual engine",
+				},
 				cli.StringFlag{
 					Name:  "base",
 					Value: "ubuntu:xenial",
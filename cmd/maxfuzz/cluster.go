@@ -0,0 +1,460 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/cluster"
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fuzzCoordinator hands targets out to workers, if they are enabled
+var fuzzCoordinator *cluster.Coordinator
+
+var sha256Pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// Storage handlers download a target and its backup to fixed local paths, so
+// only one transfer of each target is served at a time
+var storageLocks = map[string]*sync.Mutex{}
+var storageLocksLock sync.Mutex
+
+func storageLock(id string) *sync.Mutex {
+	storageLocksLock.Lock()
+	defer storageLocksLock.Unlock()
+	l, ok := storageLocks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		storageLocks[id] = l
+	}
+	return l
+}
+
+func authenticateWorker(c *gin.Context) {
+	token := c.GetHeader(constants.ClusterTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.Get().Cluster.Token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid cluster token"})
+	}
+}
+
+func registerWorker(c *gin.Context) {
+	r := cluster.Registration{}
+	err := c.BindJSON(&r)
+	if err != nil {
+		return
+	}
+	if r.Name == "" || r.Cores < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workers need a name and at least one core"})
+		return
+	}
+	fuzzCoordinator.Register(r, time.Now())
+	c.JSON(http.StatusOK, r)
+}
+
+// workerHeartbeat records the stats of a worker's targets, and answers with
+// the targets it should be fuzzing
+func workerHeartbeat(c *gin.Context) {
+	name := c.Param("name")
+	heartbeat := cluster.Heartbeat{}
+	err := c.BindJSON(&heartbeat)
+	if err != nil {
+		return
+	}
+	assignment, ok := fuzzCoordinator.Heartbeat(name, time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Worker %s is not registered", name)})
+		return
+	}
+	for _, s := range heartbeat.Stats {
+		// Stats of targets since moved to another worker are dropped
+		if s != nil && fuzzCoordinator.Worker(s.ID) == name {
+			updateStats(s)
+		}
+	}
+	c.JSON(http.StatusOK, assignment)
+}
+
+// targetStorage returns the storage handler of the target in the path,
+// replying with an error if there isn't one
+func targetStorage(c *gin.Context) (storage.StorageHandler, bool) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	_, exists := targets[id]
+	targetsLock.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return nil, false
+	}
+	h, err := storage.Init(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return h, true
+}
+
+// serveFile sends a file fetched by a storage handler, then removes it
+func serveFile(c *gin.Context, path string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(path)
+	c.File(path)
+}
+
+// saveBody writes the request body to path
+func saveBody(c *gin.Context, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, c.Request.Body)
+	return err
+}
+
+func workerGetTarget(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	l := storageLock(c.Param("id"))
+	l.Lock()
+	defer l.Unlock()
+	path, err := h.GetTarget()
+	serveFile(c, path, err)
+}
+
+func workerGetBackup(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	exists, err := h.BackupExists()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No backup"})
+		return
+	}
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	l := storageLock(c.Param("id"))
+	l.Lock()
+	defer l.Unlock()
+	err = os.MkdirAll(filepath.Dir(h.GetTargetBackupLocation()), 0755)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	path, err := h.GetBackup()
+	serveFile(c, path, err)
+}
+
+func workerPutBackup(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	l := storageLock(c.Param("id"))
+	l.Lock()
+	defer l.Unlock()
+	err := saveBody(c, h.GetTargetBackupLocation())
+	if err == nil {
+		err = h.MakeBackup()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+}
+
+func workerSavePayload(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	name := filepath.Base(c.Query("name"))
+	if name == "." || name == string(filepath.Separator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payloads need a name"})
+		return
+	}
+	found, ok := queryTime(c, "found")
+	if !ok {
+		return
+	}
+	// Payload IDs are derived from the name of the file saved
+	dir, err := ioutil.TempDir("", "maxfuzz_payload")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, name)
+	err = saveBody(c, location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	payloadID, err := h.SavePayload(storage.FuzzerPayload{
+		Location:   location,
+		Category:   c.Query("category"),
+		Revision:   c.Query("revision"),
+		TargetName: c.Query("target_name"),
+		Engine:     c.Query("engine"),
+		Image:      c.Query("image"),
+		Host:       c.Query("host"),
+		Instance:   c.Query("instance"),
+		Found:      found,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": payloadID})
+}
+
+func workerSaveOutput(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	output := storage.FuzzerPayloadOutput{}
+	err := c.BindJSON(&output)
+	if err != nil {
+		return
+	}
+	err = h.SaveOutput(output)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": output.Identifier})
+}
+
+func workerListPayloads(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	payloadIDs, err := h.ListPayloads()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payloadIDs)
+}
+
+// workerPayloadExists replies 404 through crashStorage if the payload doesn't
+// exist
+func workerPayloadExists(c *gin.Context) {
+	_, _, ok := crashStorage(c)
+	if ok {
+		c.Status(http.StatusOK)
+	}
+}
+
+func workerGetOutput(c *gin.Context) {
+	h, id, ok := crashStorage(c)
+	if !ok {
+		return
+	}
+	output, err := h.GetOutput(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if output == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Crash %s was not reproduced", id)})
+		return
+	}
+	c.JSON(http.StatusOK, output)
+}
+
+func workerGetMetadata(c *gin.Context) {
+	h, id, ok := crashStorage(c)
+	if !ok {
+		return
+	}
+	metadata, err := h.GetMetadata(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if metadata == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Crash %s has no metadata", id)})
+		return
+	}
+	c.JSON(http.StatusOK, metadata)
+}
+
+func workerSaveMetadata(c *gin.Context) {
+	h, id, ok := crashStorage(c)
+	if !ok {
+		return
+	}
+	metadata := storage.PayloadMetadata{}
+	err := c.BindJSON(&metadata)
+	if err != nil {
+		return
+	}
+	if metadata.ID != id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Metadata is for crash %s, not %s", metadata.ID, id)})
+		return
+	}
+	err = h.SaveMetadata(metadata)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+func workerGetBuckets(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	buckets, err := h.GetBuckets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, buckets)
+}
+
+func workerSaveBuckets(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	buckets := map[string]*storage.CrashBucket{}
+	err := json.NewDecoder(c.Request.Body).Decode(&buckets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.SaveBuckets(buckets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, buckets)
+}
+
+func workerListCorpus(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	hashes, err := h.ListCorpus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hashes)
+}
+
+// corpusHash returns the hash of the corpus entry in the path, replying with
+// an error if it isn't a SHA-256
+func corpusHash(c *gin.Context) (string, bool) {
+	hash := c.Param("hash")
+	if !sha256Pattern.MatchString(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid corpus entry %q, expected a SHA-256", hash)})
+		return "", false
+	}
+	return hash, true
+}
+
+func workerGetCorpusEntry(c *gin.Context) {
+	hash, ok := corpusHash(c)
+	if !ok {
+		return
+	}
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	dir, err := ioutil.TempDir("", "maxfuzz_corpus")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, hash)
+	serveFile(c, location, h.GetCorpusEntry(hash, location))
+}
+
+func workerSaveCorpusEntry(c *gin.Context) {
+	hash, ok := corpusHash(c)
+	if !ok {
+		return
+	}
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	dir, err := ioutil.TempDir("", "maxfuzz_corpus")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, hash)
+	err = saveBody(c, location)
+	if err == nil {
+		err = h.SaveCorpusEntry(hash, location)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hash": hash})
+}
+
+// addWorkerRoutes adds the API workers use to register, report stats and
+// reach the coordinator's storage
+func addWorkerRoutes(router *gin.Engine) {
+	workers := router.Group("/workers", authenticateWorker)
+	workers.POST("/register", registerWorker)
+	workers.POST("/heartbeat/:name", workerHeartbeat)
+	workers.GET("/storage/:id/target", workerGetTarget)
+	workers.GET("/storage/:id/backup", workerGetBackup)
+	workers.HEAD("/storage/:id/backup", workerGetBackup)
+	workers.PUT("/storage/:id/backup", workerPutBackup)
+	workers.GET("/storage/:id/payloads", workerListPayloads)
+	workers.POST("/storage/:id/payloads", workerSavePayload)
+	workers.GET("/storage/:id/payloads/:crashID", getCrashPayload)
+	workers.HEAD("/storage/:id/payloads/:crashID", workerPayloadExists)
+	workers.POST("/storage/:id/outputs", workerSaveOutput)
+	workers.GET("/storage/:id/outputs/:crashID", workerGetOutput)
+	workers.GET("/storage/:id/metadata/:crashID", workerGetMetadata)
+	workers.PUT("/storage/:id/metadata/:crashID", workerSaveMetadata)
+	workers.GET("/storage/:id/buckets", workerGetBuckets)
+	workers.PUT("/storage/:id/buckets", workerSaveBuckets)
+	workers.GET("/storage/:id/corpus", workerListCorpus)
+	workers.GET("/storage/:id/corpus/:hash", workerGetCorpusEntry)
+	workers.PUT("/storage/:id/corpus/:hash", workerSaveCorpusEntry)
+}
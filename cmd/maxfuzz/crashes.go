@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/everestmz/maxfuzz/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CrashDetails is a crash's metadata, with its reproduction output and the
+// frames of its bucket's signature
+type CrashDetails struct {
+	storage.PayloadMetadata
+	Frames []string                     `json:"frames"`
+	Output *storage.FuzzerPayloadOutput `json:"output"`
+}
+
+// queryTime parses the unix time in a query parameter, replying with an error
+// if it isn't one
+func queryTime(c *gin.Context, name string) (int64, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, true
+	}
+	t, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || t < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s %q, expected a unix time", name, v)})
+		return 0, false
+	}
+	return t, true
+}
+
+// Number of crashes listCrashes returns when no limit is given, and the most
+// it returns at once
+const (
+	defaultCrashesLimit = 100
+	maxCrashesLimit     = 1000
+)
+
+// queryCount parses the non-negative count in a query parameter, replying
+// with an error if it isn't one
+func queryCount(c *gin.Context, name string, fallback int) (int, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s %q, expected a count", name, v)})
+		return 0, false
+	}
+	return n, true
+}
+
+// listCrashes returns a page of the metadata of a target's crashes, newest
+// first, optionally filtered by revision, category, engine, reproduction
+// state, bucket, and the unix times since and until. The page is selected
+// with offset and limit.
+func listCrashes(c *gin.Context) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return
+	}
+	since, ok := queryTime(c, "since")
+	if !ok {
+		return
+	}
+	until, ok := queryTime(c, "until")
+	if !ok {
+		return
+	}
+	offset, ok := queryCount(c, "offset", 0)
+	if !ok {
+		return
+	}
+	limit, ok := queryCount(c, "limit", defaultCrashesLimit)
+	if !ok {
+		return
+	}
+	if limit == 0 || limit > maxCrashesLimit {
+		limit = maxCrashesLimit
+	}
+	crashes, err := storage.QueryPayloads(h, c.Param("id"), storage.PayloadQuery{
+		Revision:     c.Query("revision"),
+		Category:     c.Query("category"),
+		Engine:       c.Query("engine"),
+		Reproduction: c.Query("reproduction"),
+		Bucket:       c.Query("bucket"),
+		Since:        since,
+		Until:        until,
+		Offset:       offset,
+		Limit:        limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, crashes)
+}
+
+// crashStorage returns the storage handler of the target in the path, and the
+// ID of the crash in the path, replying with an error if it doesn't exist
+func crashStorage(c *gin.Context) (storage.StorageHandler, string, bool) {
+	h, ok := targetStorage(c)
+	if !ok {
+		return nil, "", false
+	}
+	id := c.Param("crashID")
+	if !storage.IsPayloadID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid crash ID %q", id)})
+		return nil, "", false
+	}
+	exists, err := h.PayloadExists(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	if exists {
+		return h, id, true
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Crash %s does not exist", id)})
+	return nil, "", false
+}
+
+func getCrash(c *gin.Context) {
+	h, id, ok := crashStorage(c)
+	if !ok {
+		return
+	}
+	metadata, err := storage.Metadata(h, c.Param("id"), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	output, err := h.GetOutput(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	details := CrashDetails{PayloadMetadata: *metadata, Frames: []string{}, Output: output}
+	if metadata.Bucket != "" {
+		buckets, err := h.GetBuckets()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if b, ok := buckets[metadata.Bucket]; ok {
+			details.Frames = b.Frames
+		}
+	}
+	c.JSON(http.StatusOK, details)
+}
+
+// getCrashPayload returns the input that caused a crash
+func getCrashPayload(c *gin.Context) {
+	h, id, ok := crashStorage(c)
+	if !ok {
+		return
+	}
+	dir, err := ioutil.TempDir("", "maxfuzz_payload")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, id)
+	err = h.GetPayload(id, location)
+	if err == nil {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id))
+	}
+	serveFile(c, location, err)
+}
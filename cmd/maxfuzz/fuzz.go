@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
 	"github.com/everestmz/maxfuzz/internal/supervisor"
 	"github.com/everestmz/maxfuzz/internal/types"
 
@@ -20,74 +22,53 @@ var log = &logrus.Logger{
 	Level:     logrus.DebugLevel,
 }
 
-////////////////////
-//
-// COMMON VARS
-//
 var statsChan chan *supervisor.TargetStats
 var fuzzServices = map[string]func(*types.Target, chan *supervisor.TargetStats) *suture.Supervisor{
-	"c":   supervisor.NewCFuzzer,
-	"c++": supervisor.NewCFuzzer,
-	"go":  supervisor.NewGoFuzzer,
+	"afl":       supervisor.NewCFuzzer,
+	"libfuzzer": supervisor.NewLibFuzzer,
+	"go-fuzz":   supervisor.NewGoFuzzer,
 }
 
-//
-///////////////////
-//
-// ROUND ROBIN FUZZING
-//
-var currentTarget string
-var fuzzInterval = 7200 //seconds
-var stopChan chan string
+// Stats samples are persisted to the history at most once per
+// statsHistoryInterval, keeping up to statsHistoryLimit samples per target
+var statsHistoryInterval = time.Minute
+var statsHistoryLimit = 30 * 24 * 60 // 30 days
+var lastStatsSample = map[string]time.Time{}
+
+// fuzzers runs the fuzz service of each scheduled target under its own
+// supervisor
+type fuzzers struct {
+	lock    sync.Mutex
+	running map[string]*suture.Supervisor
+}
 
-func nextTarget() string {
-	if len(targets) == 0 {
-		return ""
-	}
-	targetsLock.RLock()
-	if len(targets) == 1 {
-		for k := range targets {
-			targetsLock.RUnlock()
-			return k
-		}
-	}
-	selectedTarget := "+NOT_SELECTED+"
-	previousTarget := currentTarget
-	var metric int64
-	for k, v := range targetsTimer {
-		if selectedTarget == "+NOT_SELECTED+" {
-			selectedTarget = k
-			metric = v
-		}
-		if v < metric && k != previousTarget {
-			selectedTarget = k
-			metric = v
-		}
+func (f *fuzzers) Start(t *types.Target) error {
+	newFuzzService, ok := fuzzServices[t.Engine]
+	if !ok {
+		return fmt.Errorf("Invalid engine %s for target %s", t.Engine, t.Name)
 	}
-	targetsLock.RUnlock()
-
-	return selectedTarget
+	fuzzer := newFuzzService(t, statsChan)
+	fuzzerSupervisor := supervisor.New(logging.NewFuzzerLogger(t.Name), t.Name)
+	fuzzerSupervisor.Add(fuzzer)
+	logMessage(fmt.Sprintf("Fuzzing target %s", t.Name)).Info()
+	fuzzerSupervisor.ServeBackground()
+	f.lock.Lock()
+	f.running[t.UniqueID] = fuzzerSupervisor
+	f.lock.Unlock()
+	return nil
 }
 
-//
-///////////////////
-//
-// PARALLEL FUZZING
-//
-var parallelFuzzers map[string]*suture.Supervisor
-var parallelAddChan chan *types.Target
-
-//
-///////////////////
-func interruptTarget(t string) {
-	if fuzzStrategy == "robin" {
-		if t == currentTarget {
-			stopChan <- t
-		}
+func (f *fuzzers) Stop(id string) {
+	f.lock.Lock()
+	fuzzerSupervisor, ok := f.running[id]
+	delete(f.running, id)
+	f.lock.Unlock()
+	if !ok {
+		logMessage(fmt.Sprintf("Can't stop target %s", id)).Info()
 		return
 	}
-	// Else parallel fuzzing
-	stopChan <- t
+	logMessage(fmt.Sprintf("Killing target %s", id)).Info()
+	fuzzerSupervisor.Stop()
 }
 
 func logMessage(msg string) *logrus.Entry {
@@ -102,108 +83,53 @@ func watchStats() {
 	for {
 		select {
 		case s := <-statsChan:
-			targetsLock.Lock()
-			targetStats[s.ID] = s
-			targetsLock.Unlock()
+			updateStats(s)
 		}
 	}
 }
 
-func fuzz() {
-	stopChan = make(chan string)
-	statsChan = make(chan *supervisor.TargetStats)
-	go watchStats()
-	if fuzzStrategy == "robin" {
-		fuzzRoundRobin()
+// updateStats records the latest stats of a target, whether from its local
+// fuzzer or reported by the worker fuzzing it
+func updateStats(s *supervisor.TargetStats) {
+	if s.Minimization == nil {
+		s.Minimization = supervisor.LastMinimization(s.ID)
+	}
+	record := false
+	targetsLock.Lock()
+	if t, exists := targets[s.ID]; exists {
+		s.Engine = t.Engine
+		targetStats[s.ID] = s
+		record = sampleDue(s.ID)
 	}
-	fuzzParallel()
+	targetsLock.Unlock()
+	if record {
+		recordStats(s)
+	}
+	metrics.SetStats(s.ID, metrics.Stats{
+		ExecsPerSecond: s.TestsPerSecond,
+		TotalExecs:     s.TotalExecs,
+		Crashes:        s.Crashes,
+		Buckets:        s.BugsFound,
+		CorpusSize:     s.CorpusSize,
+	})
 }
 
-func fuzzParallel() {
-	logMessage("Waiting for targets...").Info()
-	parallelAddChan = make(chan *types.Target)
-	parallelFuzzers = map[string]*suture.Supervisor{}
-
-	for {
-		select {
-		case t := <-parallelAddChan:
-			log.Println(fmt.Sprintf("%+v", *t))
-			newFuzzService, ok := fuzzServices[t.Language]
-			if !ok {
-				logMessage(fmt.Sprintf("Invalid language %s for target %s", t.Language, t.Name)).Info()
-				continue
-			}
-			fuzzer := newFuzzService(t, statsChan)
-			fuzzerSupervisor := supervisor.New(logging.NewFuzzerLogger(t.Name), t.Name)
-			fuzzerSupervisor.Add(fuzzer)
-			logMessage(fmt.Sprintf("Fuzzing new target %s", t.Name)).Info()
-			fuzzerSupervisor.ServeBackground()
-			parallelFuzzers[t.UniqueID] = fuzzerSupervisor
-		case t := <-stopChan:
-			logMessage(fmt.Sprintf("Killing target %s", t)).Info()
-			sup, ok := parallelFuzzers[t]
-			if !ok {
-				logMessage(fmt.Sprintf("Can't stop target %s", t)).Info()
-				continue
-			}
-			sup.Stop()
-			delete(parallelFuzzers, t)
-			if len(parallelFuzzers) == 0 {
-				logMessage("Waiting for targets...").Info()
-			}
-		}
+// sampleDue reports whether a target's stats are due to be added to its
+// history, assuming they will be. Must be called with targetsLock held.
+func sampleDue(id string) bool {
+	now := time.Now()
+	if now.Sub(lastStatsSample[id]) < statsHistoryInterval {
+		return false
 	}
+	lastStatsSample[id] = now
+	return true
 }
 
-func fuzzRoundRobin() {
-	var timer *time.Timer
-	var skipFuzzerStartup = false
-	logMessage("Waiting for targets...").Info()
-
-	for {
-		targetsLock.RLock()
-		targetCount := len(targets)
-		targetsLock.RUnlock()
-		switch targetCount {
-		case 0:
-			time.Sleep(time.Second)
-		default:
-			if !skipFuzzerStartup {
-				// Get "next" target from list, set next to new one, set supervisor to fuzz target
-				logMessage(fmt.Sprintf("Fuzzer target status: %+v", targetsTimer)).Info()
-				currentTarget = nextTarget()
-
-				t := targets[currentTarget]
-				newFuzzService, ok := fuzzServices[t.Language]
-				if !ok {
-					logMessage(fmt.Sprintf("Invalid language %s for target %s", t.Language, t.Name)).Info()
-					continue
-				}
-				fuzzer := newFuzzService(targets[currentTarget], statsChan)
-				fuzzerSupervisor = supervisor.New(logging.NewFuzzerLogger(currentTarget), currentTarget)
-				fuzzerSupervisor.Add(fuzzer)
-				fuzzerSupervisor.ServeBackground()
-
-				logMessage(fmt.Sprintf("Fuzzing new target: %s...", currentTarget)).Info()
-				timer = time.NewTimer(time.Duration(fuzzInterval) * time.Second)
-			}
-			skipFuzzerStartup = false
-			select {
-			case _ = <-timer.C:
-				targetsLock.Lock()
-				targetsTimer[currentTarget] = time.Now().Unix()
-				targetsLock.Unlock()
-				if nextTarget() == currentTarget {
-					timer = time.NewTimer(time.Duration(fuzzInterval) * time.Second)
-					skipFuzzerStartup = true
-					continue
-				}
-				logMessage(fmt.Sprintf("Cycle for target %s finished. Picking new target...", currentTarget)).Info()
-			case _ = <-stopChan:
-				logMessage(fmt.Sprintf("Target %s removed. Picking new target...", currentTarget)).Info()
-			}
-			logMessage(fmt.Sprintf("Killing target %s...", currentTarget)).Info()
-			fuzzerSupervisor.Stop()
-		}
+// recordStats adds a sample to the stats history of its target. The registry
+// drops samples of targets removed in the meantime.
+func recordStats(s *supervisor.TargetStats) {
+	err := targetRegistry.PutStats(s.ID, time.Now().Unix(), s, statsHistoryLimit)
+	if err != nil {
+		logMessage(fmt.Sprintf("Could not record stats history of %s: %s", s.ID, err.Error())).Error()
 	}
 }
@@ -2,15 +2,26 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"regexp"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/everestmz/maxfuzz/internal/cluster"
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/docker"
-	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
+	"github.com/everestmz/maxfuzz/internal/registry"
+	"github.com/everestmz/maxfuzz/internal/scheduler"
 	"github.com/everestmz/maxfuzz/internal/supervisor"
 	"github.com/everestmz/maxfuzz/internal/types"
+	"github.com/everestmz/maxfuzz/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thejerf/suture"
@@ -25,35 +36,47 @@ type Status struct {
 }
 
 var targets map[string]*types.Target
-var targetsTimer map[string]int64
 var targetStats map[string]*supervisor.TargetStats
 var targetsLock sync.RWMutex
-var fuzzerSupervisor *suture.Supervisor
-var fuzzStrategy string // parallel or robin
+var targetRegistry *registry.Registry
+var fuzzScheduler scheduler.Scheduler
 
-func addTarget(t *types.Target) error {
+// addTarget registers a target and hands it to the scheduler. Paused targets
+// wait to be resumed before they start fuzzing, and restored targets carry on
+// with their persisted lifecycle. IDs reserved for uploads are only
+// registered with the uploaded target.
+func addTarget(t *types.Target, paused bool, restored *supervisor.Lifecycle) error {
 	targetsLock.Lock()
 	_, exists := targets[t.UniqueID]
-	if exists {
+	reserved, isReserved := reservedTargets[t.UniqueID]
+	if exists || (isReserved && reserved != t) {
 
 		targetsLock.Unlock()
 		return fmt.Errorf(fmt.Sprintf("Target %s already exists", t.UniqueID))
 	}
+	err := targetRegistry.PutTarget(t)
+	if err != nil {
+		targetsLock.Unlock()
+		return fmt.Errorf("Could not persist target %s: %s", t.UniqueID, err.Error())
+	}
 	targets[t.UniqueID] = t
+	delete(reservedTargets, t.UniqueID)
 	targetStats[t.UniqueID] = &supervisor.TargetStats{
 		ID:             t.UniqueID,
 		TestsPerSecond: 0,
 		BugsFound:      0,
+		Engine:         t.Engine,
 	}
-	if fuzzStrategy == "robin" {
-		// Round Robin fuzzing
-		targetsTimer[t.UniqueID] = 0
-
-	} else {
-		// Parallel fuzzing
-		parallelAddChan <- targets[t.UniqueID]
+	metrics.Register(t)
+	supervisor.TrackLifecycle(t.UniqueID, restored)
+	if paused {
+		supervisor.PauseTarget(t.UniqueID)
 	}
 	targetsLock.Unlock()
+	if paused {
+		fuzzScheduler.Pause(t.UniqueID)
+	}
+	fuzzScheduler.Add(t)
 	return nil
 }
 
@@ -64,20 +87,78 @@ func removeTarget(t *types.Target) error {
 		targetsLock.Unlock()
 		return fmt.Errorf(fmt.Sprintf("Target %s does not exist", t.UniqueID))
 	}
+	err := targetRegistry.DeleteTarget(t.UniqueID)
+	if err != nil {
+		targetsLock.Unlock()
+		return fmt.Errorf("Could not remove target %s from registry: %s", t.UniqueID, err.Error())
+	}
 	delete(targets, t.UniqueID)
 	delete(targetStats, t.UniqueID)
-	// Round robin fuzzing
-	if fuzzStrategy == "robin" {
-		delete(targetsTimer, t.UniqueID)
-	} else {
-		// Parallel fuzzing
-		stopChan <- t.UniqueID
-	}
-	interruptTarget(t.UniqueID)
+	delete(lastStatsSample, t.UniqueID)
+	metrics.Unregister(t.UniqueID)
 	targetsLock.Unlock()
+	fuzzScheduler.Remove(t.UniqueID)
+	supervisor.ForgetLifecycle(t.UniqueID)
+	return nil
+}
+
+// restoreTargets re-registers every target persisted in the registry. Each
+// target resumes from its last backup through initialFuzzerSetup, and the
+// scheduler picks up its persisted scheduling state. Paused targets stay
+// paused, and failed targets stay failed.
+func restoreTargets() error {
+	persisted, err := targetRegistry.Targets()
+	if err != nil {
+		return err
+	}
+	paused, err := targetRegistry.Paused()
+	if err != nil {
+		return err
+	}
+	lifecycles, err := targetRegistry.Lifecycles()
+	if err != nil {
+		return err
+	}
+	for _, t := range persisted {
+		log := logging.NewTargetLogger(t.Name)
+		log.Info("Restoring target from registry...")
+		// Targets registered before engines could be chosen have none, and
+		// are persisted again with their default by addTarget
+		if t.Engine == "" {
+			t.Engine = utils.DefaultEngine(t.Language)
+		}
+		_, isPaused := paused[t.UniqueID]
+		var restored *supervisor.Lifecycle
+		if data, ok := lifecycles[t.UniqueID]; ok {
+			restored = &supervisor.Lifecycle{}
+			err = json.Unmarshal(data, restored)
+			if err != nil {
+				return fmt.Errorf("Corrupt lifecycle of target %s: %s", t.UniqueID, err.Error())
+			}
+		}
+		err = addTarget(t, isPaused, restored)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// persistLifecycle saves a target's lifecycle to the registry
+func persistLifecycle(id string, l supervisor.Lifecycle) {
+	err := targetRegistry.PutLifecycle(id, l)
+	if err != nil {
+		logMessage(fmt.Sprintf("Could not persist lifecycle of %s: %s", id, err.Error())).Error()
+	}
+}
+
+// latestStats is used by the scheduler to judge targets' progress
+func latestStats(id string) *supervisor.TargetStats {
+	targetsLock.RLock()
+	defer targetsLock.RUnlock()
+	return targetStats[id]
+}
+
 func deserializeTarget(c *gin.Context) (*types.Target, error) {
 	ret := types.Target{}
 	b, err := c.GetRawData()
@@ -101,6 +182,38 @@ func listTargets(c *gin.Context) {
 	c.JSON(http.StatusOK, targetArray)
 }
 
+// Target IDs name containers and directories, so are limited to the
+// characters Docker allows in container names
+var targetIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateTarget checks the settings of a target about to be registered,
+// defaulting its engine from its language
+func validateTarget(t *types.Target) error {
+	if !targetIDPattern.MatchString(t.UniqueID) {
+		return fmt.Errorf("id must start with a letter or digit, followed by letters, digits, '_', '.' or '-'")
+	}
+	if t.Engine == "" {
+		t.Engine = utils.DefaultEngine(t.Language)
+	}
+	if !utils.SupportedEngine(t.Engine, t.Language) {
+		return fmt.Errorf("Engine %q not supported for language %q", t.Engine, t.Language)
+	}
+	if t.MinSlot < 0 || t.MaxSlot < 0 || (t.MaxSlot > 0 && t.MaxSlot < t.MinSlot) {
+		return fmt.Errorf("min_slot and max_slot must be positive, with min_slot at most max_slot")
+	}
+	if t.Weight < 0 || t.Cores < 0 {
+		return fmt.Errorf("weight and cores must be positive")
+	}
+	r := t.Resources
+	if r.CPUs < 0 || r.Memory < 0 || r.PidsLimit < 0 || r.TmpfsSize < 0 {
+		return fmt.Errorf("resource limits must be positive")
+	}
+	if r.Cpuset != "" {
+		return fmt.Errorf("cpuset is assigned by the parallel strategy, use cores instead")
+	}
+	return nil
+}
+
 func registerTarget(c *gin.Context) {
 	log.Info("Received register request...")
 	t, err := deserializeTarget(c)
@@ -108,11 +221,17 @@ func registerTarget(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	err = validateTarget(t)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	log := logging.NewTargetLogger(t.Name)
 	log.Info("Registering target...")
-	err = addTarget(t)
+	err = addTarget(t, false, nil)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	log.Info("Target registered")
 	c.JSON(http.StatusOK, t)
@@ -129,6 +248,7 @@ func unregisterTarget(c *gin.Context) {
 	err = removeTarget(t)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	log.Info("Target unregistered")
 	if len(targets) == 0 {
@@ -137,6 +257,137 @@ func unregisterTarget(c *gin.Context) {
 	c.JSON(http.StatusOK, t)
 }
 
+func minimizeTarget(c *gin.Context) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	_, exists := targets[id]
+	targetsLock.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return
+	}
+	err := supervisor.RequestMinimization(id)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+// pauseTarget freezes a target's fuzzers in place, backing them up straight
+// away. Targets paused while queued don't start until they are resumed, and
+// frozen targets are stopped when the scheduler needs their capacity.
+func pauseTarget(c *gin.Context) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	t, exists := targets[id]
+	targetsLock.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return
+	}
+	if !supervisor.PauseTarget(id) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Target %s is already paused", id)})
+		return
+	}
+	err := targetRegistry.PutPaused(id, time.Now().Unix())
+	if err != nil {
+		supervisor.ResumeTarget(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Could not persist pause of target %s: %s", id, err.Error())})
+		return
+	}
+	fuzzScheduler.Pause(id)
+	logging.NewTargetLogger(t.Name).Info("Target paused")
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+// resumeTarget lets a paused target's fuzzers carry on where they were frozen,
+// or restarts them from their backup if they were stopped meanwhile
+func resumeTarget(c *gin.Context) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	t, exists := targets[id]
+	targetsLock.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return
+	}
+	if !supervisor.ResumeTarget(id) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Target %s is not paused", id)})
+		return
+	}
+	err := targetRegistry.DeletePaused(id)
+	if err != nil {
+		supervisor.PauseTarget(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Could not persist resumption of target %s: %s", id, err.Error())})
+		return
+	}
+	fuzzScheduler.Resume(id)
+	logging.NewTargetLogger(t.Name).Info("Target resumed")
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+// statsHistory returns a target's stats samples from the unix time since,
+// downsampled to one sample per step if given
+func statsHistory(c *gin.Context) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	_, exists := targets[id]
+	targetsLock.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return
+	}
+
+	var since int64
+	var step time.Duration
+	var err error
+	if v := c.Query("since"); v != "" {
+		since, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || since < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid since %q, expected a unix time", v)})
+			return
+		}
+	}
+	if v := c.Query("step"); v != "" {
+		step, err = time.ParseDuration(v)
+		if err != nil || step < time.Second {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid step %q, expected a duration of at least 1s", v)})
+			return
+		}
+	}
+
+	history, err := targetRegistry.StatsHistory(id, since, int64(step/time.Second))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// currentStats adds the state of a target to its latest stats
+func currentStats(t *supervisor.TargetStats) *supervisor.TargetStats {
+	stats := *t
+	running := fuzzScheduler.State(t.ID) == scheduler.Running
+	if fuzzCoordinator == nil {
+		stats.OOMKills = supervisor.OOMKills(t.ID)
+		stats.Host = docker.HostName(t.ID)
+		stats.Lifecycle = supervisor.TargetLifecycle(t.ID)
+	} else {
+		// Workers report OOM kills and lifecycles, and queue targets they
+		// don't have the cores for
+		stats.Host = fuzzCoordinator.Worker(t.ID)
+		running = running && stats.Host != ""
+		if stats.Host == "" || stats.Lifecycle.State == "" {
+			stats.Lifecycle = supervisor.TargetLifecycle(t.ID)
+		}
+	}
+	if !running {
+		stats.Lifecycle.Unscheduled(supervisor.TargetPaused(t.ID))
+	}
+	return &stats
+}
+
 func status(c *gin.Context) {
 	status := Status{}
 	if len(targets) > 0 {
@@ -144,42 +395,118 @@ func status(c *gin.Context) {
 	} else {
 		status.State = "IDLE"
 	}
+	failed := 0
 	targetsLock.RLock()
 	status.Targets = []*supervisor.TargetStats{}
 	for _, t := range targetStats {
-		status.Targets = append(status.Targets, t)
+		stats := currentStats(t)
+		if stats.Lifecycle.State == supervisor.Failed {
+			failed++
+		}
+		status.Targets = append(status.Targets, stats)
 		status.BugsFound += t.BugsFound
 		status.TestsPerSecond += t.TestsPerSecond
 	}
 	targetsLock.RUnlock()
+	if failed > 0 {
+		status.State = "ERROR"
+		status.Message = fmt.Sprintf("%d targets failed", failed)
+	}
 	c.JSON(http.StatusOK, status)
 }
 
+// TargetDetails is a registered target, with its latest stats and state
+type TargetDetails struct {
+	*types.Target
+	Stats *supervisor.TargetStats `json:"stats"`
+}
+
+func getTarget(c *gin.Context) {
+	id := c.Param("id")
+	targetsLock.RLock()
+	defer targetsLock.RUnlock()
+	t, exists := targets[id]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Target %s does not exist", id)})
+		return
+	}
+	c.JSON(http.StatusOK, TargetDetails{t, currentStats(targetStats[id])})
+}
+
 func main() {
-	// TODO: add command line params for specifying directories
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		runWorker(os.Args[2:])
+		return
+	}
+
+	cfg, err := config.FromFlags(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logMessage(err.Error()).Fatal()
+	}
+	config.Set(cfg)
+
 	targetsLock = sync.RWMutex{}
 	targets = map[string]*types.Target{}
-	targetsTimer = map[string]int64{}
 	targetStats = map[string]*supervisor.TargetStats{}
-	maxfuzzOptions := helpers.MaxfuzzOptions()
-	fuzzStrategy = maxfuzzOptions["strategy"]
-	if fuzzStrategy != "robin" && fuzzStrategy != "parallel" {
-		panic("Unsupported fuzz strategy!")
+	var targetFuzzers scheduler.Fuzzers = &fuzzers{running: map[string]*suture.Supervisor{}}
+	if cfg.Cluster.Workers {
+		fuzzCoordinator = cluster.NewCoordinator(cfg.Cluster.WorkerTimeout, logging.NewFuzzerLogger(""))
+		targetFuzzers = fuzzCoordinator
+	} else {
+		err = docker.Init(cfg.Hosts())
+		if err != nil {
+			panic(err)
+		}
 	}
-	err := docker.Init()
+	targetRegistry, err = registry.Open(constants.LocalRegistryFile)
 	if err != nil {
 		panic(err)
 	}
+	defer targetRegistry.Close()
+	supervisor.PersistLifecycles(persistLifecycle)
 
-	fuzzerLogger := logging.NewFuzzerLogger("")
-	fuzzerSupervisor = supervisor.New(fuzzerLogger, "maxfuzz")
+	statsChan = make(chan *supervisor.TargetStats)
+	fuzzScheduler, err = scheduler.New(
+		cfg.Strategy,
+		targetFuzzers,
+		targetRegistry,
+		latestStats,
+		logging.NewFuzzerLogger(""),
+	)
+	if err != nil {
+		panic(err)
+	}
+	go watchStats()
+	go fuzzScheduler.Run()
+	if fuzzCoordinator != nil {
+		go fuzzCoordinator.Run(cfg.Cluster.HeartbeatInterval)
+	}
 
-	go fuzz()
+	err = restoreTargets()
+	if err != nil {
+		panic(err)
+	}
 
 	router := gin.Default()
 	router.GET("/targets", listTargets)
+	router.GET("/targets/:id", getTarget)
 	router.GET("/status", status)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	router.POST("/targets", uploadTarget)
 	router.POST("/registerTarget", registerTarget)
 	router.POST("/unregisterTarget", unregisterTarget)
-	router.Run(":8080")
+	router.GET("/targets/:id/stats/history", statsHistory)
+	router.POST("/targets/:id/minimize", minimizeTarget)
+	router.POST("/targets/:id/pause", pauseTarget)
+	router.POST("/targets/:id/resume", resumeTarget)
+	router.GET("/targets/:id/crashes", listCrashes)
+	router.GET("/targets/:id/crashes/:crashID", getCrash)
+	router.GET("/targets/:id/crashes/:crashID/payload", getCrashPayload)
+	if fuzzCoordinator != nil {
+		addWorkerRoutes(router)
+	}
+	router.Run(cfg.ListenAddress)
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest target bundle, and the most of it kept in memory while it is
+// received
+const (
+	maxBundleSize   = 1 << 30
+	maxBundleMemory = 32 << 20
+)
+
+// Targets being uploaded, by ID. Their IDs are taken until they are
+// registered, so that no other target's bundle can overwrite theirs.
+var reservedTargets = map[string]*types.Target{}
+
+// reserveTarget takes the ID of a target about to be uploaded, returning false
+// if a target is already registered or being uploaded under it
+func reserveTarget(t *types.Target) bool {
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+	_, exists := targets[t.UniqueID]
+	if exists || reservedTargets[t.UniqueID] != nil {
+		return false
+	}
+	reservedTargets[t.UniqueID] = t
+	return true
+}
+
+func releaseTarget(t *types.Target) {
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+	if reservedTargets[t.UniqueID] == t {
+		delete(reservedTargets, t.UniqueID)
+	}
+}
+
+// receiveBundle writes the uploaded bundle to a temporary file, which the
+// caller removes
+func receiveBundle(c *gin.Context) (string, error) {
+	header, err := c.FormFile("bundle")
+	if err != nil {
+		return "", fmt.Errorf("Missing bundle: %s", err.Error())
+	}
+	upload, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("Could not read bundle: %s", err.Error())
+	}
+	defer upload.Close()
+	f, err := ioutil.TempFile("", "maxfuzz_bundle")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, upload)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("Could not read bundle: %s", err.Error())
+	}
+	return f.Name(), nil
+}
+
+// uploadTarget registers a target from a multipart form holding its settings,
+// as sent to /registerTarget, in the target field, and its zipped bundle in
+// the bundle field. The bundle is stored through the target's StorageHandler,
+// where fuzzer services fetch it from.
+func uploadTarget(c *gin.Context) {
+	log.Info("Received upload request...")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBundleSize)
+	err := c.Request.ParseMultipartForm(maxBundleMemory)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid upload: %s", err.Error())})
+		return
+	}
+	t := &types.Target{}
+	err = json.Unmarshal([]byte(c.PostForm("target")), t)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid target: %s", err.Error())})
+		return
+	}
+	err = validateTarget(t)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !reserveTarget(t) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Target %s already exists", t.UniqueID)})
+		return
+	}
+	defer releaseTarget(t)
+
+	bundle, err := receiveBundle(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(bundle)
+	err = storage.ValidateBundle(bundle, t.Engine)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	log := logging.NewTargetLogger(t.Name)
+	log.Info("Storing target bundle...")
+	h, err := storage.Init(t.UniqueID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	t.Location, err = h.SaveTarget(bundle)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Could not store bundle: %s", err.Error())})
+		return
+	}
+
+	log.Info("Registering target...")
+	err = addTarget(t, false, nil)
+	if err != nil {
+		removeErr := h.DeleteTarget()
+		if removeErr != nil {
+			log.Error(fmt.Sprintf("Could not remove bundle of unregistered target: %s", removeErr.Error()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Info("Target registered")
+	c.JSON(http.StatusOK, t)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/everestmz/maxfuzz/internal/cluster"
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/scheduler"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+
+	"github.com/thejerf/suture"
+)
+
+// Latest stats of the targets fuzzed by this worker
+var workerStats = map[string]*supervisor.TargetStats{}
+var workerStatsLock sync.Mutex
+
+func watchWorkerStats() {
+	for s := range statsChan {
+		workerStatsLock.Lock()
+		workerStats[s.ID] = s
+		workerStatsLock.Unlock()
+	}
+}
+
+// workerTargetStats adds what the coordinator can't know about a target to
+// its latest stats
+func workerTargetStats(id string) *supervisor.TargetStats {
+	workerStatsLock.Lock()
+	s, ok := workerStats[id]
+	workerStatsLock.Unlock()
+	// Targets are reported while they build, before their first stats
+	stats := supervisor.TargetStats{ID: id}
+	if ok {
+		stats = *s
+	}
+	stats.OOMKills = supervisor.OOMKills(id)
+	stats.Minimization = supervisor.LastMinimization(id)
+	stats.Lifecycle = supervisor.TargetLifecycle(id)
+	return &stats
+}
+
+// runWorker fuzzes the targets a coordinator assigns to this host, with the
+// coordinator's storage
+func runWorker(args []string) {
+	cfg, err := config.FromFlags(fmt.Sprintf("%s worker", os.Args[0]), args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logMessage(err.Error()).Fatal()
+	}
+	if cfg.Cluster.Coordinator == "" {
+		logMessage("cluster.coordinator must be set to run a worker").Fatal()
+	}
+	if cfg.Cluster.Name == "" {
+		cfg.Cluster.Name, err = os.Hostname()
+		if err != nil {
+			logMessage(fmt.Sprintf("Could not name worker after its host: %s", err.Error())).Fatal()
+		}
+	}
+	cfg.Storage.Backend = "coordinator"
+	cfg.Cluster.Workers = false
+	config.Set(cfg)
+
+	err = docker.Init(cfg.Hosts())
+	if err != nil {
+		panic(err)
+	}
+
+	statsChan = make(chan *supervisor.TargetStats)
+	go watchWorkerStats()
+
+	// Assigned targets are pinned to this host's cores, and queued until
+	// they fit
+	local := scheduler.NewParallel(&fuzzers{running: map[string]*suture.Supervisor{}}, logging.NewFuzzerLogger(""))
+	worker := cluster.NewWorker(cfg.Cluster.Name, local.Cores(), cfg.Cluster, local, workerTargetStats, logging.NewFuzzerLogger(""))
+	worker.Run(cfg.Cluster.HeartbeatInterval)
+}
@@ -0,0 +1,204 @@
+package cluster
+
+// Distributed fuzzing. The coordinator runs the API and the scheduler, and
+// hands the targets the scheduler starts to workers instead of fuzzing them
+// itself. Workers poll the coordinator with heartbeats carrying their targets'
+// stats, and are answered with the targets they should be fuzzing. Crash
+// payloads and backups go through the coordinator's storage, so targets whose
+// worker is lost resume from their last backup on another worker. Each target
+// is fuzzed by one worker at a time; its corpus is synced through the
+// coordinator's storage too, see supervisor.CorpusSyncService.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/scheduler"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+// Registration is sent by workers when they start, and again whenever the
+// coordinator has lost track of them
+type Registration struct {
+	Name  string `json:"name"`
+	Cores int    `json:"cores"`
+}
+
+// Heartbeat is sent by workers every heartbeat interval, and is answered with
+// an Assignment
+type Heartbeat struct {
+	Stats []*supervisor.TargetStats `json:"stats"`
+}
+
+// Assignment lists every target a worker should be fuzzing, and which of
+// them are paused
+type Assignment struct {
+	Targets []*types.Target `json:"targets"`
+	Paused  []string        `json:"paused"`
+}
+
+type worker struct {
+	name     string
+	cores    int
+	lastSeen time.Time
+}
+
+// Coordinator assigns the targets started by the scheduler to workers,
+// placing each on the worker with the most free cores. Targets wait until a
+// worker registers if there are none.
+type Coordinator struct {
+	lock     sync.Mutex
+	workers  map[string]*worker
+	targets  map[string]*types.Target // Targets started by the scheduler
+	assigned map[string]string        // Worker fuzzing each target
+	timeout  time.Duration
+	logger   logging.Logger
+}
+
+func NewCoordinator(timeout time.Duration, l logging.Logger) *Coordinator {
+	return &Coordinator{
+		workers:  map[string]*worker{},
+		targets:  map[string]*types.Target{},
+		assigned: map[string]string{},
+		timeout:  timeout,
+		logger:   l,
+	}
+}
+
+func (c *Coordinator) Start(t *types.Target) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.targets[t.UniqueID] = t
+	c.assign()
+	return nil
+}
+
+func (c *Coordinator) Stop(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.targets, id)
+	delete(c.assigned, id)
+}
+
+// freeCores counts the cores of each worker not reserved by its targets
+func (c *Coordinator) freeCores() map[string]int {
+	toReturn := map[string]int{}
+	for name, w := range c.workers {
+		toReturn[name] = w.cores
+	}
+	for id, name := range c.assigned {
+		toReturn[name] -= scheduler.CoresNeeded(c.targets[id])
+	}
+	return toReturn
+}
+
+// assign places unassigned targets on workers. Must be called with the lock
+// held.
+func (c *Coordinator) assign() {
+	if len(c.workers) == 0 {
+		return
+	}
+	unassigned := []string{}
+	for id := range c.targets {
+		if _, ok := c.assigned[id]; !ok {
+			unassigned = append(unassigned, id)
+		}
+	}
+	sort.Strings(unassigned)
+
+	free := c.freeCores()
+	for _, id := range unassigned {
+		best := ""
+		for name, cores := range free {
+			if best == "" || cores > free[best] || (cores == free[best] && name < best) {
+				best = name
+			}
+		}
+		c.assigned[id] = best
+		free[best] -= scheduler.CoresNeeded(c.targets[id])
+		c.logger.Info(fmt.Sprintf("Assigned target %s to worker %s", id, best))
+	}
+}
+
+// Register adds a worker, or updates it if it is already known, and assigns
+// it any waiting targets
+func (c *Coordinator) Register(r Registration, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.workers[r.Name]; !ok {
+		c.logger.Info(fmt.Sprintf("Worker %s registered with %d cores", r.Name, r.Cores))
+	}
+	c.workers[r.Name] = &worker{
+		name:     r.Name,
+		cores:    r.Cores,
+		lastSeen: now,
+	}
+	c.assign()
+}
+
+// Heartbeat returns the targets a worker should be fuzzing, or false if the
+// worker isn't registered
+func (c *Coordinator) Heartbeat(name string, now time.Time) (*Assignment, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w, ok := c.workers[name]
+	if !ok {
+		return nil, false
+	}
+	w.lastSeen = now
+	toReturn := &Assignment{Targets: []*types.Target{}, Paused: []string{}}
+	for id, assignee := range c.assigned {
+		if assignee != name {
+			continue
+		}
+		toReturn.Targets = append(toReturn.Targets, c.targets[id])
+		if supervisor.TargetPaused(id) {
+			toReturn.Paused = append(toReturn.Paused, id)
+		}
+	}
+	sort.Slice(toReturn.Targets, func(i, j int) bool {
+		return toReturn.Targets[i].UniqueID < toReturn.Targets[j].UniqueID
+	})
+	sort.Strings(toReturn.Paused)
+	return toReturn, true
+}
+
+// Worker returns the worker a target is assigned to, or "" if it is waiting
+// for one
+func (c *Coordinator) Worker(id string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.assigned[id]
+}
+
+// reap drops workers that haven't sent a heartbeat within the timeout, and
+// reassigns their targets
+func (c *Coordinator) reap(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, w := range c.workers {
+		if now.Sub(w.lastSeen) < c.timeout {
+			continue
+		}
+		c.logger.Error(fmt.Sprintf("Lost worker %s, rescheduling its targets", name))
+		delete(c.workers, name)
+		for id, assignee := range c.assigned {
+			if assignee == name {
+				delete(c.assigned, id)
+			}
+		}
+	}
+	c.assign()
+}
+
+// Run drops lost workers until the process exits
+func (c *Coordinator) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		c.reap(time.Now())
+	}
+}
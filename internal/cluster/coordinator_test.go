@@ -0,0 +1,63 @@
+// +build unit
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assigned(c *Coordinator, worker string, now time.Time) []string {
+	a, ok := c.Heartbeat(worker, now)
+	if !ok {
+		return nil
+	}
+	toReturn := []string{}
+	for _, t := range a.Targets {
+		toReturn = append(toReturn, t.UniqueID)
+	}
+	return toReturn
+}
+
+func TestCoordinator(t *testing.T) {
+	c := NewCoordinator(time.Minute, logging.NewFuzzerLogger("test"))
+	start := time.Unix(1000000, 0)
+
+	// Targets wait for a worker
+	c.Start(&types.Target{UniqueID: "a", Cores: 3})
+	assert.Equal(t, "", c.Worker("a"))
+
+	c.Register(Registration{Name: "small", Cores: 2}, start)
+	c.Register(Registration{Name: "big", Cores: 8}, start)
+	assert.Equal(t, "small", c.Worker("a"))
+
+	// Targets go to the worker with the most free cores
+	c.Start(&types.Target{UniqueID: "b", Instances: 4})
+	c.Start(&types.Target{UniqueID: "c", Cores: 4})
+	c.Start(&types.Target{UniqueID: "d"})
+	assert.Equal(t, []string{"b", "c", "d"}, assigned(c, "big", start))
+	assert.Equal(t, []string{"a"}, assigned(c, "small", start))
+
+	c.Stop("d")
+	assert.Equal(t, []string{"b", "c"}, assigned(c, "big", start))
+
+	// Paused targets are assigned, and paused by their worker
+	supervisor.PauseTarget("c")
+	defer supervisor.ForgetLifecycle("c")
+	a, _ := c.Heartbeat("big", start)
+	assert.Equal(t, []string{"c"}, a.Paused)
+
+	// Targets of lost workers are rescheduled
+	c.reap(start.Add(30 * time.Second))
+	assert.Equal(t, "small", c.Worker("a"))
+	assigned(c, "big", start.Add(45*time.Second))
+	c.reap(start.Add(time.Minute))
+	assert.Equal(t, []string{"a", "b", "c"}, assigned(c, "big", start.Add(time.Minute)))
+	assert.Nil(t, assigned(c, "small", start.Add(time.Minute)))
+}
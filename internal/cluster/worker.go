@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/scheduler"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+// errUnregistered is returned by heartbeats the coordinator doesn't recognise
+var errUnregistered = fmt.Errorf("Worker is not registered with the coordinator")
+
+// Worker fuzzes the targets the coordinator assigns to it, through a local
+// scheduler. If it loses touch with the coordinator for half the worker
+// timeout it stops fuzzing, so that it is down by the time the coordinator
+// gives up on it and reschedules its targets.
+type Worker struct {
+	name     string
+	cores    int
+	url      string
+	token    string
+	timeout  time.Duration
+	client   *http.Client
+	local    scheduler.Scheduler
+	stats    scheduler.StatsFunc
+	logger   logging.Logger
+	running  map[string]*types.Target
+	lastBeat time.Time
+}
+
+func NewWorker(name string, cores int, c config.Cluster, local scheduler.Scheduler, stats scheduler.StatsFunc, l logging.Logger) *Worker {
+	return &Worker{
+		name:    name,
+		cores:   cores,
+		url:     strings.TrimRight(c.Coordinator, "/"),
+		token:   c.Token,
+		timeout: c.WorkerTimeout / 2,
+		client:  &http.Client{Timeout: c.HeartbeatInterval},
+		local:   local,
+		stats:   stats,
+		logger:  l,
+		running: map[string]*types.Target{},
+	}
+}
+
+// post sends v to the coordinator, decoding its response into out
+func (w *Worker) post(path string, v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(constants.ClusterTokenHeader, w.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not reach coordinator: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errUnregistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Coordinator returned %s for %s", resp.Status, path)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (w *Worker) register() error {
+	return w.post("/workers/register", Registration{Name: w.name, Cores: w.cores}, nil)
+}
+
+// beat reports the stats of the worker's targets, and applies the assignment
+// the coordinator answers with
+func (w *Worker) beat(now time.Time) error {
+	heartbeat := Heartbeat{}
+	for id := range w.running {
+		s := w.stats(id)
+		if s == nil {
+			continue
+		}
+		stats := *s
+		// Targets waiting for cores are reported queued
+		if w.local.State(id) != scheduler.Running {
+			stats.Lifecycle.Unscheduled(supervisor.TargetPaused(id))
+		}
+		stats.Host = w.name
+		heartbeat.Stats = append(heartbeat.Stats, &stats)
+	}
+	assignment := &Assignment{}
+	err := w.post(fmt.Sprintf("/workers/heartbeat/%s", url.PathEscape(w.name)), heartbeat, assignment)
+	if err != nil {
+		return err
+	}
+	w.lastBeat = now
+	w.apply(assignment)
+	return nil
+}
+
+// apply starts newly assigned targets and stops those no longer assigned,
+// and pauses or resumes them as the coordinator was asked to
+func (w *Worker) apply(a *Assignment) {
+	assigned := map[string]*types.Target{}
+	for _, t := range a.Targets {
+		assigned[t.UniqueID] = t
+	}
+	paused := map[string]bool{}
+	for _, id := range a.Paused {
+		paused[id] = true
+	}
+	for id := range w.running {
+		if _, ok := assigned[id]; !ok {
+			w.logger.Info(fmt.Sprintf("Target %s is no longer assigned to this worker", id))
+			w.local.Remove(id)
+			delete(w.running, id)
+		}
+	}
+	for id, t := range assigned {
+		if paused[id] && supervisor.PauseTarget(id) {
+			w.logger.Info(fmt.Sprintf("Pausing target %s", id))
+			w.local.Pause(id)
+		} else if !paused[id] && supervisor.ResumeTarget(id) {
+			w.logger.Info(fmt.Sprintf("Resuming target %s", id))
+			w.local.Resume(id)
+		}
+		if _, ok := w.running[id]; !ok {
+			w.logger.Info(fmt.Sprintf("Target %s assigned to this worker", id))
+			w.local.Add(t)
+			w.running[id] = t
+		}
+	}
+}
+
+// Run registers with the coordinator and sends heartbeats every interval
+// until the process exits
+func (w *Worker) Run(interval time.Duration) {
+	go w.local.Run()
+	registered := false
+	ticker := time.NewTicker(interval)
+	for now := time.Now(); ; now = <-ticker.C {
+		if !registered {
+			err := w.register()
+			if err != nil {
+				w.logger.Error(fmt.Sprintf("Could not register with coordinator: %s", err.Error()))
+			} else {
+				w.logger.Info(fmt.Sprintf("Registered with coordinator %s as %s", w.url, w.name))
+				registered = true
+			}
+		}
+		if registered {
+			err := w.beat(now)
+			if err == errUnregistered {
+				registered = false
+			}
+			if err != nil {
+				w.logger.Error(fmt.Sprintf("Heartbeat failed: %s", err.Error()))
+			}
+		}
+		if len(w.running) > 0 && now.Sub(w.lastBeat) >= w.timeout {
+			w.logger.Error("Lost touch with coordinator, stopping every target")
+			w.apply(&Assignment{})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// +build unit
+
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testScheduler records the targets a worker fuzzes
+type testScheduler map[string]bool
+
+func (s testScheduler) Add(t *types.Target) { s[t.UniqueID] = true }
+func (s testScheduler) Remove(id string)    { delete(s, id) }
+func (s testScheduler) Pause(id string)     {}
+func (s testScheduler) Resume(id string)    {}
+func (s testScheduler) State(id string) string {
+	return "RUNNING"
+}
+func (s testScheduler) Run() {}
+
+func (s testScheduler) running() []string {
+	toReturn := []string{}
+	for id := range s {
+		toReturn = append(toReturn, id)
+	}
+	sort.Strings(toReturn)
+	return toReturn
+}
+
+// fakeCoordinator serves a Coordinator's register and heartbeat endpoints,
+// recording the stats it is sent
+func fakeCoordinator(c *Coordinator, received map[string]*supervisor.TargetStats) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/workers/register", func(w http.ResponseWriter, r *http.Request) {
+		registration := Registration{}
+		json.NewDecoder(r.Body).Decode(&registration)
+		c.Register(registration, time.Now())
+	})
+	mux.HandleFunc("/workers/heartbeat/worker", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(constants.ClusterTokenHeader) != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		heartbeat := Heartbeat{}
+		json.NewDecoder(r.Body).Decode(&heartbeat)
+		for _, s := range heartbeat.Stats {
+			received[s.ID] = s
+		}
+		a, ok := c.Heartbeat("worker", time.Now())
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(a)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestWorker(t *testing.T) {
+	c := NewCoordinator(time.Minute, logging.NewFuzzerLogger("test"))
+	received := map[string]*supervisor.TargetStats{}
+	server := fakeCoordinator(c, received)
+	defer server.Close()
+
+	local := testScheduler{}
+	stats := func(id string) *supervisor.TargetStats {
+		return &supervisor.TargetStats{ID: id, TotalExecs: 100}
+	}
+	cfg := config.Default().Cluster
+	cfg.Coordinator = server.URL + "/"
+	cfg.Token = "secret"
+	w := NewWorker("worker", 4, cfg, local, stats, logging.NewFuzzerLogger("test"))
+	now := time.Now()
+
+	// Heartbeats from workers the coordinator doesn't know are refused
+	assert.Equal(t, errUnregistered, w.beat(now))
+	assert.Nil(t, w.register())
+	c.Start(&types.Target{UniqueID: "a"})
+	c.Start(&types.Target{UniqueID: "b"})
+	assert.Nil(t, w.beat(now))
+	assert.Equal(t, []string{"a", "b"}, local.running())
+
+	c.Stop("a")
+	assert.Nil(t, w.beat(now))
+	assert.Equal(t, []string{"b"}, local.running())
+	assert.Equal(t, int64(100), received["b"].TotalExecs)
+	assert.Equal(t, "worker", received["b"].Host)
+}
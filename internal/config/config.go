@@ -0,0 +1,327 @@
+package config
+
+// Typed Maxfuzz configuration, read from a YAML file and overridden by
+// command line flags. It is validated once at startup, after which services
+// read it through Get.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	multierror "github.com/hashicorp/go-multierror"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Strategy             string        `yaml:"strategy"`    // parallel, robin, weighted or hybrid
+	Concurrency          int           `yaml:"concurrency"` // Targets fuzzed at once by the hybrid strategy
+	Cores                int           `yaml:"cores"`       // Cores shared out by the parallel strategy, 0 for every core
+	ListenAddress        string        `yaml:"listen_address"`
+	DockerEndpoint       string        `yaml:"docker_endpoint"`
+	DockerTLS            TLS           `yaml:"docker_tls"`
+	DockerHosts          []DockerHost  `yaml:"docker_hosts"` // Replaces docker_endpoint to fuzz on several hosts
+	BackupInterval       time.Duration `yaml:"backup_interval"`
+	RobinInterval        time.Duration `yaml:"robin_interval"` // Time each target gets when fuzzing round robin
+	RobinMinSlot         time.Duration `yaml:"robin_min_slot"` // Default bounds of a round robin slot, see scheduler.Slot
+	RobinMaxSlot         time.Duration `yaml:"robin_max_slot"`
+	PlateauWindow        time.Duration `yaml:"plateau_window"`       // 0 disables plateau detection
+	MinimizeInterval     time.Duration `yaml:"minimize_interval"`    // 0 only minimizes corpora on request
+	CorpusSyncInterval   time.Duration `yaml:"corpus_sync_interval"` // 0 disables corpus sync between hosts
+	MaxFailures          int           `yaml:"max_failures"`         // Failures in a row before a target is FAILED, 0 to retry forever
+	SuppressFuzzerOutput bool          `yaml:"suppress_fuzzer_output"`
+
+	Directories  Directories  `yaml:"directories"`
+	Storage      Storage      `yaml:"storage"`
+	Reproduction Reproduction `yaml:"reproduction"`
+	Cluster      Cluster      `yaml:"cluster"`
+}
+
+// TLS holds the client certificate, key and CA used to reach a docker daemon
+// over TCP. Plain connections are used when they aren't set.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	CA   string `yaml:"ca"`
+}
+
+func (t TLS) valid() bool {
+	none := t.Cert == "" && t.Key == "" && t.CA == ""
+	all := t.Cert != "" && t.Key != "" && t.CA != ""
+	return none || all
+}
+
+type DockerHost struct {
+	Name        string          `yaml:"name"`
+	Endpoint    string          `yaml:"endpoint"`
+	TLS         TLS             `yaml:"tls"`
+	Cores       int             `yaml:"cores"`       // 0 asks the daemon how many the host has
+	Directories HostDirectories `yaml:"directories"` // Where the host sees the shared directories
+}
+
+// HostDirectories are the paths of the sync and targets directories on a
+// docker host, when they aren't the same as they are locally
+type HostDirectories struct {
+	Sync    string `yaml:"sync"`
+	Targets string `yaml:"targets"`
+}
+
+type Directories struct {
+	Sync     string `yaml:"sync"`     // Where fuzzer output is synced to
+	Targets  string `yaml:"targets"`  // Where targets are unpacked
+	Crashes  string `yaml:"crashes"`  // Where crashes are saved by local storage
+	Registry string `yaml:"registry"` // Registered targets database
+}
+
+type Storage struct {
+	Backend string `yaml:"backend"` // local or s3
+	S3      S3     `yaml:"s3"`
+}
+
+type S3 struct {
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"` // For S3 compatible services
+	Prefix    string `yaml:"prefix"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+type Reproduction struct {
+	Queue    string `yaml:"queue"` // memory or rmq
+	RedisURL string `yaml:"redis_url"`
+}
+
+// Cluster configures distributed fuzzing, where a coordinator schedules
+// targets onto workers started with maxfuzz worker
+type Cluster struct {
+	Workers           bool          `yaml:"workers"`     // Fuzz targets on workers instead of locally, on the coordinator
+	Coordinator       string        `yaml:"coordinator"` // URL of the coordinator, on workers
+	Name              string        `yaml:"name"`        // Name of the worker, its hostname if not set
+	Token             string        `yaml:"token"`       // Shared secret workers authenticate with
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	WorkerTimeout     time.Duration `yaml:"worker_timeout"` // Workers silent for this long are lost and their targets rescheduled
+}
+
+func Default() *Config {
+	return &Config{
+		Strategy:           "parallel",
+		Concurrency:        2,
+		ListenAddress:      ":8080",
+		DockerEndpoint:     "unix:///var/run/docker.sock",
+		BackupInterval:     10 * time.Minute,
+		RobinInterval:      2 * time.Hour,
+		RobinMinSlot:       30 * time.Minute,
+		RobinMaxSlot:       8 * time.Hour,
+		PlateauWindow:      30 * time.Minute,
+		MinimizeInterval:   6 * time.Hour,
+		CorpusSyncInterval: 5 * time.Minute,
+		MaxFailures:        5,
+		Directories: Directories{
+			Sync:     constants.LocalSyncDirectory,
+			Targets:  constants.LocalTargetDirectory,
+			Crashes:  constants.LocalCrashStorage,
+			Registry: constants.LocalRegistryFile,
+		},
+		Storage: Storage{
+			Backend: "local",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+		Reproduction: Reproduction{
+			Queue:    "memory",
+			RedisURL: "localhost:6379",
+		},
+		Cluster: Cluster{
+			HeartbeatInterval: 10 * time.Second,
+			WorkerTimeout:     time.Minute,
+		},
+	}
+}
+
+// Load reads a YAML config file. Settings missing from the file keep their
+// default values, and environment variables are expanded in directories.
+func Load(path string) (*Config, error) {
+	toReturn := Default()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s", err.Error())
+	}
+	err = yaml.UnmarshalStrict(b, toReturn)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse config file %s: %s", path, err.Error())
+	}
+	d := &toReturn.Directories
+	for _, p := range []*string{&d.Sync, &d.Targets, &d.Crashes, &d.Registry} {
+		*p = os.ExpandEnv(*p)
+	}
+	for i := range toReturn.DockerHosts {
+		h := &toReturn.DockerHosts[i].Directories
+		h.Sync = os.ExpandEnv(h.Sync)
+		h.Targets = os.ExpandEnv(h.Targets)
+	}
+	return toReturn, nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var result *multierror.Error
+	invalid := func(format string, args ...interface{}) {
+		result = multierror.Append(result, fmt.Errorf(format, args...))
+	}
+
+	switch c.Strategy {
+	case "parallel", "robin", "weighted":
+	case "hybrid":
+		if c.Concurrency < 1 {
+			invalid("concurrency must be at least 1 to use the hybrid strategy, not %d", c.Concurrency)
+		}
+	default:
+		invalid("strategy must be parallel, robin, weighted or hybrid, not %q", c.Strategy)
+	}
+	if c.ListenAddress == "" {
+		invalid("listen_address must be set")
+	}
+	if c.DockerEndpoint == "" && len(c.DockerHosts) == 0 {
+		invalid("docker_endpoint must be set")
+	}
+	if !c.DockerTLS.valid() {
+		invalid("docker_tls needs a cert, key and ca, or none of them")
+	}
+	names := map[string]bool{}
+	for i, h := range c.DockerHosts {
+		if h.Name == "" || names[h.Name] {
+			invalid("docker_hosts[%d].name must be set and unique, not %q", i, h.Name)
+		}
+		names[h.Name] = true
+		if h.Endpoint == "" {
+			invalid("docker_hosts[%d].endpoint must be set", i)
+		}
+		if !h.TLS.valid() {
+			invalid("docker_hosts[%d].tls needs a cert, key and ca, or none of them", i)
+		}
+		if h.Cores < 0 {
+			invalid("docker_hosts[%d].cores can't be negative", i)
+		}
+		for _, d := range []string{h.Directories.Sync, h.Directories.Targets} {
+			if d != "" && !filepath.IsAbs(d) {
+				invalid("docker_hosts[%d].directories must be absolute paths, not %q", i, d)
+			}
+		}
+	}
+	if c.BackupInterval <= 0 {
+		invalid("backup_interval must be positive, not %s", c.BackupInterval)
+	}
+	if c.RobinInterval <= 0 {
+		invalid("robin_interval must be positive, not %s", c.RobinInterval)
+	}
+	if c.Cores < 0 {
+		invalid("cores can't be negative")
+	}
+	if c.RobinMinSlot <= 0 || c.RobinMaxSlot < c.RobinMinSlot {
+		invalid("robin_min_slot must be positive and at most robin_max_slot, not %s and %s", c.RobinMinSlot, c.RobinMaxSlot)
+	}
+	if c.PlateauWindow < 0 {
+		invalid("plateau_window can't be negative")
+	}
+	if c.MinimizeInterval < 0 {
+		invalid("minimize_interval can't be negative")
+	}
+	if c.CorpusSyncInterval < 0 {
+		invalid("corpus_sync_interval can't be negative")
+	}
+	if c.MaxFailures < 0 {
+		invalid("max_failures can't be negative")
+	}
+
+	directories := map[string]string{
+		"directories.sync":     c.Directories.Sync,
+		"directories.targets":  c.Directories.Targets,
+		"directories.crashes":  c.Directories.Crashes,
+		"directories.registry": c.Directories.Registry,
+	}
+	for name, d := range directories {
+		if !filepath.IsAbs(d) {
+			invalid("%s must be an absolute path, not %q", name, d)
+		}
+	}
+
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		if c.Storage.S3.Bucket == "" {
+			invalid("storage.s3.bucket must be set to use s3 storage")
+		}
+	case "coordinator":
+		if c.Cluster.Coordinator == "" {
+			invalid("cluster.coordinator must be set to use coordinator storage")
+		}
+	default:
+		invalid("storage.backend must be local, s3 or coordinator, not %q", c.Storage.Backend)
+	}
+
+	switch c.Reproduction.Queue {
+	case "memory":
+	case "rmq":
+		if c.Reproduction.RedisURL == "" {
+			invalid("reproduction.redis_url must be set to use the rmq queue")
+		}
+	default:
+		invalid("reproduction.queue must be memory or rmq, not %q", c.Reproduction.Queue)
+	}
+
+	// Workers stop fuzzing after half the timeout, checking every heartbeat
+	if c.Cluster.HeartbeatInterval <= 0 || c.Cluster.WorkerTimeout <= 2*c.Cluster.HeartbeatInterval {
+		invalid("cluster.heartbeat_interval must be positive and less than half of cluster.worker_timeout, not %s and %s", c.Cluster.HeartbeatInterval, c.Cluster.WorkerTimeout)
+	}
+	if c.Cluster.Workers && c.Cluster.Token == "" {
+		invalid("cluster.token must be set to fuzz on workers")
+	}
+
+	if result != nil {
+		return fmt.Errorf("Invalid configuration: %s", result.Error())
+	}
+	return nil
+}
+
+// Hosts returns the docker hosts fuzzers run on, which is the host at
+// docker_endpoint unless docker_hosts are configured
+func (c *Config) Hosts() []DockerHost {
+	if len(c.DockerHosts) > 0 {
+		return c.DockerHosts
+	}
+	return []DockerHost{
+		{
+			Name:     "local",
+			Endpoint: c.DockerEndpoint,
+			TLS:      c.DockerTLS,
+		},
+	}
+}
+
+var current = Default()
+var lock sync.RWMutex
+
+// Set replaces the configuration in use. The local directories in constants
+// are updated to match it.
+func Set(c *Config) {
+	lock.Lock()
+	defer lock.Unlock()
+	current = c
+	constants.LocalSyncDirectory = c.Directories.Sync
+	constants.LocalTargetDirectory = c.Directories.Targets
+	constants.LocalCrashStorage = c.Directories.Crashes
+	constants.LocalRegistryFile = c.Directories.Registry
+}
+
+// Get returns the configuration in use
+func Get() *Config {
+	lock.RLock()
+	defer lock.RUnlock()
+	return current
+}
@@ -0,0 +1,98 @@
+// +build unit
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "maxfuzz_config")
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString(contents)
+	assert.Nil(t, err)
+	return f.Name()
+}
+
+func TestLoad(t *testing.T) {
+	os.Setenv("MAXFUZZ_TEST_DIR", "/srv")
+	path := writeConfig(t, `
+strategy: robin
+robin_interval: 30m
+directories:
+  sync: $MAXFUZZ_TEST_DIR/sync
+storage:
+  backend: s3
+  s3:
+    bucket: fuzzing
+`)
+	defer os.Remove(path)
+
+	c, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "robin", c.Strategy)
+	assert.Equal(t, 30*time.Minute, c.RobinInterval)
+	assert.Equal(t, "/srv/sync", c.Directories.Sync)
+	assert.Equal(t, "fuzzing", c.Storage.S3.Bucket)
+	// Missing settings keep their defaults
+	assert.Equal(t, ":8080", c.ListenAddress)
+	assert.Equal(t, "us-east-1", c.Storage.S3.Region)
+	assert.Nil(t, c.Validate())
+
+	unknown := writeConfig(t, "stratgy: robin\n")
+	defer os.Remove(unknown)
+	_, err = Load(unknown)
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Default().Validate())
+
+	c := Default()
+	c.Strategy = "random"
+	c.BackupInterval = 0
+	c.Directories.Sync = "sync"
+	c.Storage.Backend = "s3"
+	err := c.Validate()
+	assert.NotNil(t, err)
+	for _, setting := range []string{"strategy", "backup_interval", "directories.sync", "storage.s3.bucket"} {
+		assert.Contains(t, err.Error(), setting)
+	}
+}
+
+func TestFromFlags(t *testing.T) {
+	path := writeConfig(t, "strategy: robin\nlisten_address: \":9000\"\n")
+	defer os.Remove(path)
+
+	c, err := FromFlags("maxfuzz", []string{"-config", path, "-listen", ":9090", "-backup-interval", "1m"})
+	assert.Nil(t, err)
+	assert.Equal(t, "robin", c.Strategy)
+	assert.Equal(t, ":9090", c.ListenAddress)
+	assert.Equal(t, time.Minute, c.BackupInterval)
+
+	_, err = FromFlags("maxfuzz", []string{"-strategy", "random"})
+	assert.NotNil(t, err)
+}
+
+func TestDockerHosts(t *testing.T) {
+	c := Default()
+	assert.Equal(t, []DockerHost{{Name: "local", Endpoint: "unix:///var/run/docker.sock"}}, c.Hosts())
+
+	c.DockerTLS.Cert = "/certs/cert.pem"
+	c.DockerHosts = []DockerHost{
+		{Name: "a", Endpoint: "tcp://10.0.0.1:2376"},
+		{Name: "a", TLS: TLS{Cert: "/certs/cert.pem"}},
+	}
+	assert.Equal(t, c.DockerHosts, c.Hosts())
+	err := c.Validate()
+	assert.NotNil(t, err)
+	for _, setting := range []string{"docker_tls", "docker_hosts[1].name", "docker_hosts[1].endpoint", "docker_hosts[1].tls"} {
+		assert.Contains(t, err.Error(), setting)
+	}
+}
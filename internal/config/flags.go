@@ -0,0 +1,76 @@
+package config
+
+import (
+	"flag"
+)
+
+// bindFlags binds command line flags to the settings of c. Every flag
+// defaults to the current value of its setting.
+func bindFlags(name string, c *Config, configFile *string) *flag.FlagSet {
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.StringVar(configFile, "config", *configFile, "YAML config file")
+	f.StringVar(&c.Strategy, "strategy", c.Strategy, "fuzzing strategy, parallel, robin, weighted or hybrid")
+	f.IntVar(&c.Concurrency, "concurrency", c.Concurrency, "targets fuzzed at once by the hybrid strategy")
+	f.IntVar(&c.Cores, "cores", c.Cores, "cores shared out by the parallel strategy, 0 for every core")
+	f.StringVar(&c.ListenAddress, "listen", c.ListenAddress, "address the API listens on")
+	f.StringVar(&c.DockerEndpoint, "docker-endpoint", c.DockerEndpoint, "docker daemon endpoint")
+	f.StringVar(&c.DockerTLS.Cert, "docker-tls-cert", c.DockerTLS.Cert, "client certificate for a docker daemon using TLS")
+	f.StringVar(&c.DockerTLS.Key, "docker-tls-key", c.DockerTLS.Key, "client key for a docker daemon using TLS")
+	f.StringVar(&c.DockerTLS.CA, "docker-tls-ca", c.DockerTLS.CA, "CA certificate of a docker daemon using TLS")
+	f.DurationVar(&c.BackupInterval, "backup-interval", c.BackupInterval, "time between backups of each target")
+	f.DurationVar(&c.RobinInterval, "robin-interval", c.RobinInterval, "time each target is fuzzed for when fuzzing round robin")
+	f.DurationVar(&c.RobinMinSlot, "robin-min-slot", c.RobinMinSlot, "shortest round robin slot of a target")
+	f.DurationVar(&c.RobinMaxSlot, "robin-max-slot", c.RobinMaxSlot, "longest round robin slot of a target")
+	f.DurationVar(&c.PlateauWindow, "plateau-window", c.PlateauWindow, "end a round robin slot once the target hasn't found a new path for this long, 0 to use fixed slots")
+	f.DurationVar(&c.MinimizeInterval, "minimize-interval", c.MinimizeInterval, "time between corpus minimizations, 0 to only minimize on request")
+	f.DurationVar(&c.CorpusSyncInterval, "corpus-sync-interval", c.CorpusSyncInterval, "time between corpus syncs with other hosts, 0 to disable them")
+	f.IntVar(&c.MaxFailures, "max-failures", c.MaxFailures, "failures in a row before a target stops being retried, 0 to retry forever")
+	f.BoolVar(&c.SuppressFuzzerOutput, "suppress-output", c.SuppressFuzzerOutput, "don't log fuzzer output")
+
+	f.StringVar(&c.Directories.Sync, "sync-dir", c.Directories.Sync, "directory fuzzer output is synced to")
+	f.StringVar(&c.Directories.Targets, "targets-dir", c.Directories.Targets, "directory targets are unpacked to")
+	f.StringVar(&c.Directories.Crashes, "crashes-dir", c.Directories.Crashes, "directory crashes are saved to by local storage")
+	f.StringVar(&c.Directories.Registry, "registry", c.Directories.Registry, "registered targets database")
+
+	f.StringVar(&c.Storage.Backend, "storage", c.Storage.Backend, "storage backend, local or s3")
+	f.StringVar(&c.Storage.S3.Bucket, "s3-bucket", c.Storage.S3.Bucket, "S3 bucket")
+	f.StringVar(&c.Storage.S3.Region, "s3-region", c.Storage.S3.Region, "S3 region")
+	f.StringVar(&c.Storage.S3.Endpoint, "s3-endpoint", c.Storage.S3.Endpoint, "endpoint of an S3 compatible service")
+	f.StringVar(&c.Storage.S3.Prefix, "s3-prefix", c.Storage.S3.Prefix, "prefix of every S3 key")
+	f.BoolVar(&c.Storage.S3.PathStyle, "s3-path-style", c.Storage.S3.PathStyle, "use path style S3 URLs")
+
+	f.StringVar(&c.Reproduction.Queue, "reproduction-queue", c.Reproduction.Queue, "crash reproduction queue, memory or rmq")
+	f.StringVar(&c.Reproduction.RedisURL, "redis-url", c.Reproduction.RedisURL, "redis instance used by the rmq queue")
+
+	f.BoolVar(&c.Cluster.Workers, "workers", c.Cluster.Workers, "fuzz targets on registered workers instead of locally")
+	f.StringVar(&c.Cluster.Coordinator, "coordinator", c.Cluster.Coordinator, "URL of the coordinator a worker registers with")
+	f.StringVar(&c.Cluster.Name, "worker-name", c.Cluster.Name, "name of the worker, its hostname if not set")
+	f.StringVar(&c.Cluster.Token, "cluster-token", c.Cluster.Token, "shared secret workers authenticate with")
+	f.DurationVar(&c.Cluster.HeartbeatInterval, "heartbeat-interval", c.Cluster.HeartbeatInterval, "time between worker heartbeats")
+	f.DurationVar(&c.Cluster.WorkerTimeout, "worker-timeout", c.Cluster.WorkerTimeout, "time after which a silent worker's targets are rescheduled")
+	return f
+}
+
+// FromFlags builds the configuration from the config file given with -config,
+// overridden by any other flags given, and validates it
+func FromFlags(name string, args []string) (*Config, error) {
+	var configFile string
+	// The first pass only looks for the config file
+	err := bindFlags(name, Default(), &configFile).Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if configFile != "" {
+		c, err = Load(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = bindFlags(name, c, &configFile).Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
@@ -11,10 +11,15 @@ var (
 	FuzzerEnvironment     = "/root/fuzzer/environment"
 	FuzzerBuildSteps      = "/root/fuzzer/build_steps"
 	AFLIOOptions          = "/root/config/afl-io/options"
+	ReproductionInput     = "/root/fuzz_in/input"
+	ReproduceStdin        = "/usr/local/bin/reproduce_stdin"
 	// Local file constants
-	LocalSyncDirectory   = os.ExpandEnv("$HOME/maxfuzz/sync")    // Where the crashes are synced to on root
-	LocalTargetDirectory = os.ExpandEnv("$HOME/maxfuzz/targets") // Where targets are on the root system
-	LocalCrashStorage    = os.ExpandEnv("$HOME/maxfuzz/crashes") // Where we save the final crashes & output
+	LocalSyncDirectory   = os.ExpandEnv("$HOME/maxfuzz/sync")        // Where the crashes are synced to on root
+	LocalTargetDirectory = os.ExpandEnv("$HOME/maxfuzz/targets")     // Where targets are on the root system
+	LocalCrashStorage    = os.ExpandEnv("$HOME/maxfuzz/crashes")     // Where we save the final crashes & output
+	LocalRegistryFile    = os.ExpandEnv("$HOME/maxfuzz/registry.db") // Where registered targets are persisted
 	// Docker Images
 	FuzzBoxImageName = "maxfuzz"
+	// Cluster
+	ClusterTokenHeader = "X-Maxfuzz-Token" // Carries the token workers authenticate with
 )
@@ -1,27 +1,63 @@
 package docker
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/types"
 
 	d "github.com/fsouza/go-dockerclient"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/subosito/gotenv"
 )
 
-var client *d.Client
+// ErrOOMKilled is returned when a build is killed for exceeding its memory
+// limit
+var ErrOOMKilled = errors.New("Build ran out of memory and was killed")
+
+// Images targets' fuzzers were last created from, and the resource limits
+// they were created with, which one-off runs in the images are held to too
+var images = map[string]string{}
+var imageResources = map[string]types.Resources{}
+var imagesLock sync.Mutex
+
+// ImageID returns the ID of the image a target's fuzzers were last created
+// from, or "" if they weren't
+func ImageID(target string) string {
+	imagesLock.Lock()
+	defer imagesLock.Unlock()
+	return images[target]
+}
+
+// ErrBuildStopped is returned, possibly along with errors cleaning up, when a
+// build is stopped before it finishes
+var ErrBuildStopped = errors.New("Fuzzer creation stopped")
 
-func Init() error {
-	var err error
+// cpuPeriod is the CFS period, in microseconds, CPU quotas are given over
+var cpuPeriod int64 = 100000
 
-	endpoint := "unix:///var/run/docker.sock"
-	client, err = d.NewClient(endpoint)
-	return err
+// limitResources applies a target's resource limits to a container
+func limitResources(hostConfig *d.HostConfig, r types.Resources) {
+	if r.CPUs > 0 {
+		hostConfig.CPUPeriod = cpuPeriod
+		hostConfig.CPUQuota = int64(r.CPUs * float64(cpuPeriod))
+	}
+	hostConfig.CPUSetCPUs = r.Cpuset
+	if r.Memory > 0 {
+		hostConfig.Memory = r.Memory
+		hostConfig.MemorySwap = r.Memory // No swap on top of the limit
+	}
+	hostConfig.PidsLimit = r.PidsLimit
+	if r.TmpfsSize > 0 {
+		hostConfig.Tmpfs = map[string]string{"/tmp": fmt.Sprintf("rw,exec,size=%d", r.TmpfsSize)}
+	}
 }
 
 type FuzzClusterConfiguration struct {
@@ -31,22 +67,39 @@ type FuzzClusterConfiguration struct {
 	syncDirectory string
 	portBindings  map[d.Port][]d.PortBinding
 	exposedPorts  map[d.Port]struct{}
+	resources     types.Resources
 }
 
-func (c *FuzzClusterConfiguration) Deploy(command []string, stdout, stderr io.Writer) (*FuzzCluster, error) {
-	configuration := d.Config{
-		Image:        c.imageID,
-		AttachStdin:  true,
-		AttachStdout: true,
-		Entrypoint:   command,
-		Env:          c.environment,
-		ExposedPorts: c.exposedPorts,
+// fuzzerName names the containers of a fuzz cluster. The first keeps the
+// name used by single container clusters.
+func fuzzerName(target string, instance int) string {
+	if instance == 0 {
+		return fmt.Sprintf("%s_fuzzer", target)
 	}
-	createContainerOptions := d.CreateContainerOptions{
-		Name:   fmt.Sprintf("%s_fuzzer", c.Target),
-		Config: &configuration,
-		HostConfig: &d.HostConfig{
-			Mounts: []d.HostMount{
+	return fmt.Sprintf("%s_fuzzer_%d", target, instance)
+}
+
+// Deploy starts one fuzzer container per command, all sharing the sync
+// directory. Ports are only exposed by the first container.
+func (c *FuzzClusterConfiguration) Deploy(commands [][]string, stdout, stderr io.Writer) (*FuzzCluster, error) {
+	h := hostOf(c.Target)
+	toReturn := &FuzzCluster{
+		Target:        c.Target,
+		Fuzzers:       []string{},
+		imageID:       c.imageID,
+		environment:   c.environment,
+		syncDirectory: c.syncDirectory,
+	}
+	for i, command := range commands {
+		configuration := d.Config{
+			Image:        c.imageID,
+			AttachStdin:  true,
+			AttachStdout: true,
+			Entrypoint:   command,
+			Env:          c.environment,
+		}
+		hostConfig := &d.HostConfig{
+			Mounts: h.mounts([]d.HostMount{
 				{
 					Target:   constants.FuzzerLocation,
 					Source:   filepath.Join(constants.LocalTargetDirectory, c.Target),
@@ -59,80 +112,183 @@ func (c *FuzzClusterConfiguration) Deploy(command []string, stdout, stderr io.Wr
 					Type:     "bind",
 					ReadOnly: false,
 				},
-			},
-			AutoRemove:   true,
-			PortBindings: c.portBindings,
-		},
-		NetworkingConfig: &d.NetworkingConfig{},
-	}
+			}),
+			// Not removed automatically, so that their state can be
+			// inspected once they stop. Kill removes them.
+		}
+		limitResources(hostConfig, c.resources)
+		if i == 0 {
+			configuration.ExposedPorts = c.exposedPorts
+			hostConfig.PortBindings = c.portBindings
+		}
+		createContainerOptions := d.CreateContainerOptions{
+			Name:             fuzzerName(c.Target, i),
+			Config:           &configuration,
+			HostConfig:       hostConfig,
+			NetworkingConfig: &d.NetworkingConfig{},
+		}
 
-	cont, err := client.CreateContainer(createContainerOptions)
-	if err != nil {
-		return nil, err
-	}
+		cont, err := h.client.CreateContainer(createContainerOptions)
+		if err != nil {
+			toReturn.Kill()
+			return nil, err
+		}
+		toReturn.Fuzzers = append(toReturn.Fuzzers, cont.Name)
 
-	err = client.StartContainer(cont.ID, &d.HostConfig{})
-	if err != nil {
-		return nil, err
-	}
+		err = h.client.StartContainer(cont.ID, &d.HostConfig{})
+		if err != nil {
+			toReturn.Kill()
+			return nil, err
+		}
 
-	go followContainerCustomWriters(cont.ID, stdout, stderr)
+		go followContainerCustomWriters(h.client, cont.ID, stdout, stderr)
+	}
 
-	return &FuzzCluster{
-		Target:        c.Target,
-		Fuzzer:        cont.Name,
-		imageID:       c.imageID,
-		environment:   c.environment,
-		syncDirectory: c.syncDirectory,
-	}, nil
+	return toReturn, nil
 }
 
+// FuzzCluster is the group of fuzzer containers running a target
 type FuzzCluster struct {
-	Target        string //target id
-	Fuzzer        string
-	imageID       string //base image with built fuzzer
+	Target        string   //target id
+	Fuzzers       []string // Container names, the first is the main fuzzer
+	imageID       string   //base image with built fuzzer
 	environment   []string
 	syncDirectory string
 }
 
 func (c *FuzzCluster) State() (*FuzzClusterState, error) {
 	toReturn := &FuzzClusterState{}
-	fuzzer, err := client.InspectContainer(c.Fuzzer)
-	if err != nil {
-		return toReturn, err
+	client := clientOf(c.Target)
+	for _, name := range c.Fuzzers {
+		fuzzer, err := client.InspectContainer(name)
+		if err != nil {
+			return toReturn, err
+		}
+		toReturn.Fuzzers = append(toReturn.Fuzzers, fuzzer.State)
 	}
-	toReturn.Fuzzer = fuzzer.State
 	return toReturn, nil
 }
 
 func (c *FuzzCluster) Kill() error {
+	client := clientOf(c.Target)
 	var result *multierror.Error
-	result = multierror.Append(result,
-		client.StopContainer(c.Fuzzer, 1),
-		client.RemoveContainer(
-			d.RemoveContainerOptions{
-				ID:    c.Fuzzer,
-				Force: true,
-			},
-		),
-	)
+	for _, name := range c.Fuzzers {
+		result = multierror.Append(result,
+			client.StopContainer(name, 1),
+			client.RemoveContainer(
+				d.RemoveContainerOptions{
+					ID:    name,
+					Force: true,
+				},
+			),
+		)
+	}
 
 	return result.ErrorOrNil()
 }
 
 type FuzzClusterState struct {
-	Fuzzer d.State
+	Fuzzers []d.State
 }
 
+// Running is true while every fuzzer in the cluster is running
 func (s *FuzzClusterState) Running() bool {
-	return s.Fuzzer.Running
+	for _, state := range s.Fuzzers {
+		if !state.Running {
+			return false
+		}
+	}
+	return len(s.Fuzzers) > 0
+}
+
+// OOMKilled is true if any fuzzer in the cluster was killed for exceeding its
+// memory limit
+func (s *FuzzClusterState) OOMKilled() bool {
+	for _, state := range s.Fuzzers {
+		if state.OOMKilled {
+			return true
+		}
+	}
+	return false
 }
 
+// ExitCode returns the exit code of the first fuzzer to have stopped
 func (s *FuzzClusterState) ExitCode() int {
-	return s.Fuzzer.ExitCode
+	for _, state := range s.Fuzzers {
+		if !state.Running {
+			return state.ExitCode
+		}
+	}
+	return 0
+}
+
+// fuzzerContainers lists every fuzzer container of a target on a host,
+// however many instances its last cluster had
+func (h *host) fuzzerContainers(target string) ([]d.APIContainers, error) {
+	return h.client.ListContainers(d.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"name": {fmt.Sprintf("^/%s_fuzzer", target)}},
+	})
+}
+
+func fuzzerContainers(target string) ([]d.APIContainers, error) {
+	return hostOf(target).fuzzerContainers(target)
+}
+
+// KillFuzzers stops and removes every fuzzer container of a target, on every
+// host it may have been left on
+func KillFuzzers(target string) error {
+	var result *multierror.Error
+	for _, h := range hostsOf(target) {
+		containers, err := h.fuzzerContainers(target)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		for _, cont := range containers {
+			h.client.StopContainer(cont.ID, 1)
+			result = multierror.Append(result,
+				h.client.RemoveContainer(
+					d.RemoveContainerOptions{
+						ID:    cont.ID,
+						Force: true,
+					},
+				),
+			)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// PauseFuzzers freezes every fuzzer container of a target
+func PauseFuzzers(target string) error {
+	containers, err := fuzzerContainers(target)
+	if err != nil {
+		return err
+	}
+	client := clientOf(target)
+	var result *multierror.Error
+	for _, cont := range containers {
+		result = multierror.Append(result, client.PauseContainer(cont.ID))
+	}
+	return result.ErrorOrNil()
+}
+
+// UnpauseFuzzers resumes the fuzzer containers paused by PauseFuzzers
+func UnpauseFuzzers(target string) error {
+	containers, err := fuzzerContainers(target)
+	if err != nil {
+		return err
+	}
+	client := clientOf(target)
+	var result *multierror.Error
+	for _, cont := range containers {
+		result = multierror.Append(result, client.UnpauseContainer(cont.ID))
+	}
+	return result.ErrorOrNil()
 }
 
-func followContainer(id string) error {
+func followContainer(client *d.Client, id string) error {
 	options := d.LogsOptions{
 		Container:    id,
 		Since:        0,
@@ -146,7 +302,7 @@ func followContainer(id string) error {
 	return client.Logs(options)
 }
 
-func followContainerCustomWriters(id string, out, err io.Writer) error {
+func followContainerCustomWriters(client *d.Client, id string, out, err io.Writer) error {
 	options := d.LogsOptions{
 		Container:    id,
 		Since:        0,
@@ -166,14 +322,30 @@ func targetToRepository(target string) string {
 
 type EmptyStruct struct{}
 
-func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[string]string, stdout, stderr io.Writer) (*FuzzClusterConfiguration, error) {
+func targetEnvironment(target string) ([]string, error) {
+	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, target, "environment"))
+	if err != nil {
+		return nil, err
+	}
+	defer environmentFile.Close()
+	environmentMap := gotenv.Parse(environmentFile)
+	environment := []string{}
+	for k, v := range environmentMap {
+		environment = append(environment, fmt.Sprintf("%s=%s", k, v))
+	}
+	return environment, nil
+}
+
+// CreateFuzzer builds a target in its buildbox and commits the result as the
+// image its fuzzers run in. Both are limited to resources.
+func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[string]string, resources types.Resources, stdout, stderr io.Writer) (*FuzzClusterConfiguration, error) {
 	toReturn := &FuzzClusterConfiguration{
 		Target:       target,
 		portBindings: map[d.Port][]d.PortBinding{},
 		exposedPorts: map[d.Port]struct{}{},
+		resources:    resources,
 	}
 	buildboxName := fmt.Sprintf("%s_buildbox", target)
-	fuzzerName := fmt.Sprintf("%s_fuzzer", target)
 	reproducerName := fmt.Sprintf("%s_reproducer", target)
 
 	for container, host := range exposePorts {
@@ -188,27 +360,33 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 		toReturn.exposedPorts[key] = EmptyStruct{}
 	}
 
-	//Make sure all old containers are killed and removed (buildbox, fuzzer, repro)
-	for _, box := range []string{buildboxName, fuzzerName, reproducerName} {
-		client.StopContainer(box, 1)
-		client.RemoveContainer(
-			d.RemoveContainerOptions{
-				ID:    box,
-				Force: true,
-			},
-		)
+	//Make sure all old containers are killed and removed (buildbox, fuzzers, repro)
+	//from the host the target was last placed on, or every host if it wasn't
+	//placed since maxfuzz started
+	KillFuzzers(target)
+	minimizerName := fmt.Sprintf("%s_minimizer", target)
+	for _, old := range hostsOf(target) {
+		for _, box := range []string{buildboxName, fuzzerName(target, 0), reproducerName, minimizerName} {
+			old.client.StopContainer(box, 1)
+			old.client.RemoveContainer(
+				d.RemoveContainerOptions{
+					ID:    box,
+					Force: true,
+				},
+			)
+		}
 	}
 
-	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, target, "environment"))
+	h, err := place(target)
 	if err != nil {
 		return nil, err
 	}
-	environmentMap := gotenv.Parse(environmentFile)
-	environment := []string{}
-	for k, v := range environmentMap {
-		environment = append(environment, fmt.Sprintf("%s=%s", k, v))
+	client := h.client
+
+	environment, err := targetEnvironment(target)
+	if err != nil {
+		return nil, err
 	}
-	environmentFile.Close()
 
 	// Ensure sync dir exists
 	syncDirectory := filepath.Join(constants.LocalSyncDirectory, target)
@@ -228,7 +406,7 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 		Name:   buildboxName,
 		Config: &configuration,
 		HostConfig: &d.HostConfig{
-			Mounts: []d.HostMount{
+			Mounts: h.mounts([]d.HostMount{
 				{
 					Target:   constants.FuzzerLocation,
 					Source:   filepath.Join(constants.LocalTargetDirectory, target),
@@ -241,10 +419,11 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 					Type:     "bind",
 					ReadOnly: false,
 				},
-			},
+			}),
 		},
 		NetworkingConfig: &d.NetworkingConfig{},
 	}
+	limitResources(createContainerOptions.HostConfig, resources)
 
 	cont, err := client.CreateContainer(createContainerOptions)
 	if err != nil {
@@ -256,7 +435,7 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 		return nil, err
 	}
 
-	go followContainerCustomWriters(cont.ID, stdout, stderr)
+	go followContainerCustomWriters(client, cont.ID, stdout, stderr)
 
 	cont, err = client.InspectContainer(cont.ID)
 	if err != nil {
@@ -269,7 +448,7 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 		case <-stop:
 			var result *multierror.Error
 			result = multierror.Append(result,
-				fmt.Errorf("Fuzzer creation stopped"),
+				ErrBuildStopped,
 				client.StopContainer(buildboxName, 1),
 				client.RemoveContainer(
 					d.RemoveContainerOptions{
@@ -288,6 +467,15 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 	}
 
 	ticker.Stop()
+	if cont.State.OOMKilled {
+		client.RemoveContainer(
+			d.RemoveContainerOptions{
+				ID:    cont.ID,
+				Force: true,
+			},
+		)
+		return nil, ErrOOMKilled
+	}
 	if cont.State.Status != "FINISHED" && cont.State.ExitCode != 0 {
 		return nil, fmt.Errorf("Error running build files - please check logs")
 	}
@@ -312,8 +500,156 @@ func CreateFuzzer(target, baseImage string, stop chan bool, exposePorts map[stri
 		return nil, err
 	}
 
+	imagesLock.Lock()
+	images[target] = image.ID
+	imageResources[target] = resources
+	imagesLock.Unlock()
+
 	toReturn.imageID = image.ID
 	toReturn.environment = environment
 	toReturn.syncDirectory = syncDirectory
 	return toReturn, err
 }
+
+// Reproduction is the result of a one-off run in a target's committed image
+type Reproduction struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	TimedOut bool
+}
+
+// Reproduce runs command against a single crash input in the target's
+// committed fuzzer image. The input is mounted at constants.ReproductionInput.
+func Reproduce(target string, command []string, input string, timeout time.Duration) (*Reproduction, error) {
+	return runInImage(fmt.Sprintf("%s_reproducer", target), target, command, []d.HostMount{
+		{
+			Target:   constants.FuzzerLocation,
+			Source:   filepath.Join(constants.LocalTargetDirectory, target),
+			Type:     "bind",
+			ReadOnly: true,
+		},
+		{
+			Target:   constants.ReproductionInput,
+			Source:   input,
+			Type:     "bind",
+			ReadOnly: true,
+		},
+	}, timeout)
+}
+
+// Minimize runs a corpus minimization command in the target's committed
+// fuzzer image, with the sync directory mounted as it is for the fuzzers
+func Minimize(target string, command []string, timeout time.Duration) (*Reproduction, error) {
+	return runInImage(fmt.Sprintf("%s_minimizer", target), target, command, []d.HostMount{
+		{
+			Target:   constants.FuzzerLocation,
+			Source:   filepath.Join(constants.LocalTargetDirectory, target),
+			Type:     "bind",
+			ReadOnly: true,
+		},
+		{
+			Target:   constants.FuzzerOutputDirectory,
+			Source:   filepath.Join(constants.LocalSyncDirectory, target),
+			Type:     "bind",
+			ReadOnly: false,
+		},
+	}, timeout)
+}
+
+// runInImage runs command to completion in a container of the target's
+// committed image, within the target's resource limits, killing it after
+// timeout
+func runInImage(name, target string, command []string, mounts []d.HostMount, timeout time.Duration) (*Reproduction, error) {
+	h := hostOf(target)
+	client := h.client
+	client.StopContainer(name, 1)
+	client.RemoveContainer(
+		d.RemoveContainerOptions{
+			ID:    name,
+			Force: true,
+		},
+	)
+
+	environment, err := targetEnvironment(target)
+	if err != nil {
+		return nil, err
+	}
+
+	configuration := d.Config{
+		Image:        targetToRepository(target),
+		AttachStdout: true,
+		AttachStderr: true,
+		Entrypoint:   command,
+		Env:          environment,
+	}
+	createContainerOptions := d.CreateContainerOptions{
+		Name:   name,
+		Config: &configuration,
+		HostConfig: &d.HostConfig{
+			Mounts: h.mounts(mounts),
+		},
+		NetworkingConfig: &d.NetworkingConfig{},
+	}
+	imagesLock.Lock()
+	limitResources(createContainerOptions.HostConfig, imageResources[target])
+	imagesLock.Unlock()
+
+	cont, err := client.CreateContainer(createContainerOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer client.RemoveContainer(
+		d.RemoveContainerOptions{
+			ID:    cont.ID,
+			Force: true,
+		},
+	)
+
+	err = client.StartContainer(cont.ID, &d.HostConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := &Reproduction{}
+	type waitResult struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := client.WaitContainer(cont.ID)
+		done <- waitResult{exitCode, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	var result waitResult
+	select {
+	case result = <-done:
+		timer.Stop()
+	case <-timer.C:
+		toReturn.TimedOut = true
+		client.KillContainer(d.KillContainerOptions{ID: cont.ID})
+		result = <-done
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	toReturn.ExitCode = result.exitCode
+
+	var stdout, stderr bytes.Buffer
+	err = client.Logs(d.LogsOptions{
+		Container:    cont.ID,
+		Stdout:       true,
+		Stderr:       true,
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	toReturn.Stdout = stdout.Bytes()
+	toReturn.Stderr = stderr.Bytes()
+
+	return toReturn, nil
+}
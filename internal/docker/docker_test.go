@@ -0,0 +1,40 @@
+// +build unit
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	d "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitResources(t *testing.T) {
+	hostConfig := &d.HostConfig{}
+	limitResources(hostConfig, types.Resources{})
+	assert.Equal(t, &d.HostConfig{}, hostConfig)
+
+	limitResources(hostConfig, types.Resources{
+		CPUs:      1.5,
+		Cpuset:    "0-3",
+		Memory:    1 << 30,
+		PidsLimit: 256,
+		TmpfsSize: 1 << 28,
+	})
+	assert.Equal(t, int64(150000), hostConfig.CPUQuota)
+	assert.Equal(t, int64(100000), hostConfig.CPUPeriod)
+	assert.Equal(t, "0-3", hostConfig.CPUSetCPUs)
+	assert.Equal(t, int64(1<<30), hostConfig.Memory)
+	assert.Equal(t, int64(1<<30), hostConfig.MemorySwap)
+	assert.Equal(t, int64(256), hostConfig.PidsLimit)
+	assert.Equal(t, map[string]string{"/tmp": "rw,exec,size=268435456"}, hostConfig.Tmpfs)
+}
+
+func TestFuzzClusterStateOOMKilled(t *testing.T) {
+	state := &FuzzClusterState{Fuzzers: []d.State{{Running: true}, {ExitCode: 137, OOMKilled: true}}}
+	assert.False(t, state.Running())
+	assert.True(t, state.OOMKilled())
+	assert.Equal(t, 137, state.ExitCode())
+}
@@ -0,0 +1,225 @@
+package docker
+
+// Fuzzers can run on several docker hosts. Each target is placed on the host
+// with the most free cores whenever its fuzzer is created, and the rest of its
+// containers run there until it is next created.
+//
+// Containers bind mount the sync and targets directories, so remote hosts must
+// share them with maxfuzz, e.g. over NFS, for targets to reach the host and
+// for stats and crashes to be collected back. Base images must be available on
+// every host.
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	d "github.com/fsouza/go-dockerclient"
+)
+
+type host struct {
+	name            string
+	client          *d.Client
+	address         string // Where ports published by the host's containers are reachable
+	cores           int
+	syncDirectory   string // Where the host sees the sync directory, "" if at the same path
+	targetDirectory string
+}
+
+var hosts = []*host{}
+var placements = map[string]*host{} // Host each target was last placed on
+var placementLock sync.Mutex
+
+func newHost(c config.DockerHost) (*host, error) {
+	var client *d.Client
+	var err error
+	if c.TLS.Cert != "" {
+		client, err = d.NewTLSClient(c.Endpoint, c.TLS.Cert, c.TLS.Key, c.TLS.CA)
+	} else {
+		client, err = d.NewClient(c.Endpoint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to docker host %s: %s", c.Name, err.Error())
+	}
+
+	toReturn := &host{
+		name:            c.Name,
+		client:          client,
+		address:         "0.0.0.0",
+		cores:           c.Cores,
+		syncDirectory:   c.Directories.Sync,
+		targetDirectory: c.Directories.Targets,
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err == nil && u.Scheme != "unix" && u.Hostname() != "" {
+		toReturn.address = u.Hostname()
+	}
+	if toReturn.cores == 0 {
+		info, err := client.Info()
+		if err != nil {
+			return nil, fmt.Errorf("Could not get the cores of docker host %s: %s", c.Name, err.Error())
+		}
+		toReturn.cores = info.NCPU
+	}
+	return toReturn, nil
+}
+
+// Init connects to every docker host fuzzers can run on
+func Init(configured []config.DockerHost) error {
+	connected := []*host{}
+	for _, c := range configured {
+		h, err := newHost(c)
+		if err != nil {
+			return err
+		}
+		connected = append(connected, h)
+	}
+
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	hosts = connected
+	placements = map[string]*host{}
+	return nil
+}
+
+// hostOf returns the host a target was placed on, or the first host if it
+// hasn't been placed yet
+func hostOf(target string) *host {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	h, ok := placements[target]
+	if !ok {
+		return hosts[0]
+	}
+	return h
+}
+
+// hostsOf returns the host a target was placed on, or every host if it
+// hasn't been placed since maxfuzz started, as its containers may have been
+// left on any of them
+func hostsOf(target string) []*host {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	h, ok := placements[target]
+	if !ok {
+		return append([]*host{}, hosts...)
+	}
+	return []*host{h}
+}
+
+func clientOf(target string) *d.Client {
+	return hostOf(target).client
+}
+
+// freeCores counts the cores of a host not taken by a running fuzzer, each
+// fuzzer container being expected to keep one core busy
+func (h *host) freeCores() (int, error) {
+	containers, err := h.client.ListContainers(d.ListContainersOptions{
+		Filters: map[string][]string{"name": {"_fuzzer"}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return h.cores - len(containers), nil
+}
+
+// place picks the host with the most free cores to run a target on. Hosts
+// that can't be reached are skipped.
+func place(target string) (*host, error) {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	if len(hosts) == 1 {
+		placements[target] = hosts[0]
+		return hosts[0], nil
+	}
+
+	var toReturn *host
+	mostFree := 0
+	var lastErr error
+	for _, h := range hosts {
+		free, err := h.freeCores()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if toReturn == nil || free > mostFree {
+			toReturn = h
+			mostFree = free
+		}
+	}
+	if toReturn == nil {
+		return nil, fmt.Errorf("Could not reach any docker host: %s", lastErr.Error())
+	}
+	placements[target] = toReturn
+	return toReturn, nil
+}
+
+// path translates a local path in the sync or targets directory to where the
+// host sees it
+func (h *host) path(local string) string {
+	shared := map[string]string{
+		constants.LocalSyncDirectory:   h.syncDirectory,
+		constants.LocalTargetDirectory: h.targetDirectory,
+	}
+	for localDirectory, hostDirectory := range shared {
+		if hostDirectory == "" {
+			continue
+		}
+		rel, err := filepath.Rel(localDirectory, local)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.Join(hostDirectory, rel)
+		}
+	}
+	return local
+}
+
+// mounts translates the sources of bind mounts with path
+func (h *host) mounts(m []d.HostMount) []d.HostMount {
+	toReturn := []d.HostMount{}
+	for _, mount := range m {
+		mount.Source = h.path(mount.Source)
+		toReturn = append(toReturn, mount)
+	}
+	return toReturn
+}
+
+// HostName returns the name of the host a target was placed on, or "" if it
+// hasn't been placed yet
+func HostName(target string) string {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	h, ok := placements[target]
+	if !ok {
+		return ""
+	}
+	return h.name
+}
+
+// HostAddress returns the address ports published by a target's fuzzers can
+// be reached at
+func HostAddress(target string) string {
+	return hostOf(target).address
+}
+
+// Capacity is the total number of cores of every host
+func Capacity() int {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	toReturn := 0
+	for _, h := range hosts {
+		toReturn += h.cores
+	}
+	return toReturn
+}
+
+// MultiHost is true when fuzzers can run on more than one host
+func MultiHost() bool {
+	placementLock.Lock()
+	defer placementLock.Unlock()
+	return len(hosts) > 1
+}
@@ -0,0 +1,101 @@
+// +build unit
+
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	d "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDaemon serves just enough of the docker API to place targets, running
+// the given number of fuzzers on a host with the given number of cores
+func fakeDaemon(cores, fuzzers int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			json.NewEncoder(w).Encode(map[string]int{"NCPU": cores})
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			json.NewEncoder(w).Encode(make([]d.APIContainers, fuzzers))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestPlacement(t *testing.T) {
+	busy := fakeDaemon(8, 6)
+	defer busy.Close()
+	idle := fakeDaemon(4, 1)
+	defer idle.Close()
+
+	err := Init([]config.DockerHost{
+		{Name: "busy", Endpoint: busy.URL},
+		{Name: "idle", Endpoint: idle.URL},
+		{Name: "down", Endpoint: "tcp://127.0.0.1:1", Cores: 64},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 76, Capacity())
+	assert.True(t, MultiHost())
+
+	assert.Equal(t, "", HostName("target"))
+	// Unplaced targets may have containers left on any host
+	assert.Equal(t, 3, len(hostsOf("target")))
+	h, err := place("target")
+	assert.Nil(t, err)
+	assert.Equal(t, "idle", h.name)
+	assert.Equal(t, "idle", HostName("target"))
+	assert.Equal(t, []*host{h}, hostsOf("target"))
+	assert.Equal(t, "127.0.0.1", HostAddress("target"))
+}
+
+func TestHostPath(t *testing.T) {
+	constants.LocalSyncDirectory = "/maxfuzz/sync"
+	constants.LocalTargetDirectory = "/maxfuzz/targets"
+	h := &host{syncDirectory: "/mnt/sync"}
+
+	assert.Equal(t, "/mnt/sync/target", h.path("/maxfuzz/sync/target"))
+	assert.Equal(t, "/maxfuzz/targets/target", h.path("/maxfuzz/targets/target"))
+	assert.Equal(t, "/maxfuzz/sync_old", h.path("/maxfuzz/sync_old"))
+}
+
+func TestDeployMounts(t *testing.T) {
+	constants.LocalSyncDirectory = "/maxfuzz/sync"
+	constants.LocalTargetDirectory = "/maxfuzz/targets"
+	mounts := []d.HostMount{}
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/create") {
+			http.NotFound(w, r)
+			return
+		}
+		var created struct{ HostConfig d.HostConfig }
+		json.NewDecoder(r.Body).Decode(&created)
+		mounts = created.HostConfig.Mounts
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d.Container{ID: "fuzzer"})
+	}))
+	defer daemon.Close()
+	err := Init([]config.DockerHost{{
+		Name:        "remote",
+		Endpoint:    daemon.URL,
+		Cores:       1,
+		Directories: config.HostDirectories{Sync: "/mnt/sync", Targets: "/mnt/targets"},
+	}})
+	assert.Nil(t, err)
+
+	// Fuzzers mount the shared directories where the host sees them
+	c := &FuzzClusterConfiguration{Target: "target", syncDirectory: "/maxfuzz/sync/target"}
+	_, err = c.Deploy([][]string{{"fuzz"}}, nil, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(mounts))
+	assert.Equal(t, "/mnt/targets/target", mounts[0].Source)
+	assert.Equal(t, "/mnt/sync/target", mounts[1].Source)
+}
@@ -75,7 +75,7 @@ func filesystemRegularBackup(fs afero.Fs, localBackupDir string, log *logrus.Log
 	progress := func(path string) {
 		// Add prints here to show zip progress
 	}
-	err = zip.ArchiveFile(localBackupDir, outFilePath, progress)
+	err := zip.ArchiveFile(localBackupDir, outFilePath, progress)
 	Check("Unable to zip backup directory: %v", err)
 	filesystemSync(fs, outFilePath, fmt.Sprintf("%v_backup.zip", fuzzer), log)
 }
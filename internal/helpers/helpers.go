@@ -3,7 +3,6 @@ package helpers
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
@@ -14,20 +13,6 @@ var fs = afero.NewOsFs()
 var fuzzer = Getenv("FUZZER_NAME", "test")
 var revision = Getenv("GIT_SHA", "no_git")
 
-func MaxfuzzOptions() map[string]string {
-	result := GetenvOrDie("MAXFUZZ_OPTIONS")
-	ret := map[string]string{}
-	split := strings.Split(result, ":")
-	for _, v := range split {
-		vals := strings.Split(v, "=")
-		key := vals[0]
-		val := vals[1]
-		ret[key] = val
-	}
-
-	return ret
-}
-
 func Getenv(key, def string) string {
 	temp := os.Getenv(key)
 	if len(temp) == 0 {
@@ -1,3 +0,0 @@
-// +build unit
-
-package helpers
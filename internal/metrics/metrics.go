@@ -0,0 +1,176 @@
+package metrics
+
+// Exposes per-target fuzzing metrics in the Prometheus format. Stats are
+// pushed in by the coordinator, and lifecycle events by the supervisor
+// services of each target.
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Container states
+const (
+	Building = "building"
+	Running  = "running"
+	Paused   = "paused"
+	Stopped  = "stopped"
+)
+
+var containerStates = []string{Building, Running, Paused, Stopped}
+
+var labels = []string{"id", "name", "language", "revision"}
+
+func newDesc(name, help string, extraLabels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName("maxfuzz", "", name),
+		help,
+		append(append([]string{}, labels...), extraLabels...),
+		nil,
+	)
+}
+
+var (
+	execsPerSecondDesc = newDesc("execs_per_second", "Test cases executed per second.")
+	execsDesc          = newDesc("execs_total", "Test cases executed.")
+	crashesDesc        = newDesc("crashes_total", "Crashes reported by the fuzzer.")
+	bucketsDesc        = newDesc("crash_buckets", "Unique crash buckets.")
+	corpusSizeDesc     = newDesc("corpus_size", "Inputs in the fuzzer's corpus.")
+	restartsDesc       = newDesc("fuzzer_restarts_total", "Times the fuzzer was restarted.")
+	buildDurationDesc  = newDesc("build_duration_seconds", "Duration of the last fuzzer build.")
+	backupsDesc        = newDesc("backups_total", "Backups attempted.", "result")
+	containerStateDesc = newDesc("container_state", "State of the fuzzer container.", "state")
+)
+
+type Stats struct {
+	ExecsPerSecond float64
+	TotalExecs     int64
+	Crashes        int
+	Buckets        int
+	CorpusSize     int
+}
+
+type targetMetrics struct {
+	target         types.Target
+	stats          Stats
+	starts         int
+	buildDuration  time.Duration
+	backupSuccess  int
+	backupFailure  int
+	containerState string
+}
+
+var targets = map[string]*targetMetrics{}
+var lock sync.Mutex
+
+// Register starts exporting metrics for a target
+func Register(t *types.Target) {
+	lock.Lock()
+	defer lock.Unlock()
+	targets[t.UniqueID] = &targetMetrics{
+		target:         *t,
+		containerState: Stopped,
+	}
+}
+
+func Unregister(id string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(targets, id)
+}
+
+// update calls f with the metrics of a registered target
+func update(id string, f func(*targetMetrics)) {
+	lock.Lock()
+	defer lock.Unlock()
+	m, ok := targets[id]
+	if ok {
+		f(m)
+	}
+}
+
+func SetStats(id string, s Stats) {
+	update(id, func(m *targetMetrics) { m.stats = s })
+}
+
+func FuzzerStarted(id string) {
+	update(id, func(m *targetMetrics) { m.starts++ })
+}
+
+func BuildFinished(id string, d time.Duration) {
+	update(id, func(m *targetMetrics) { m.buildDuration = d })
+}
+
+func BackupFinished(id string, err error) {
+	update(id, func(m *targetMetrics) {
+		if err != nil {
+			m.backupFailure++
+		} else {
+			m.backupSuccess++
+		}
+	})
+}
+
+func SetContainerState(id, state string) {
+	update(id, func(m *targetMetrics) { m.containerState = state })
+}
+
+type collector struct{}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		execsPerSecondDesc, execsDesc, crashesDesc, bucketsDesc, corpusSizeDesc,
+		restartsDesc, buildDurationDesc, backupsDesc, containerStateDesc,
+	} {
+		ch <- d
+	}
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, m := range targets {
+		l := []string{m.target.UniqueID, m.target.Name, m.target.Language, m.target.Revision}
+		gauge := func(desc *prometheus.Desc, v float64, extra ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append(l, extra...)...)
+		}
+		counter := func(desc *prometheus.Desc, v float64, extra ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, append(l, extra...)...)
+		}
+
+		restarts := 0
+		if m.starts > 1 {
+			restarts = m.starts - 1
+		}
+		gauge(execsPerSecondDesc, m.stats.ExecsPerSecond)
+		counter(execsDesc, float64(m.stats.TotalExecs))
+		counter(crashesDesc, float64(m.stats.Crashes))
+		gauge(bucketsDesc, float64(m.stats.Buckets))
+		gauge(corpusSizeDesc, float64(m.stats.CorpusSize))
+		counter(restartsDesc, float64(restarts))
+		gauge(buildDurationDesc, m.buildDuration.Seconds())
+		counter(backupsDesc, float64(m.backupSuccess), "success")
+		counter(backupsDesc, float64(m.backupFailure), "failure")
+		for _, state := range containerStates {
+			value := 0.0
+			if state == m.containerState {
+				value = 1
+			}
+			gauge(containerStateDesc, value, state)
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(collector{})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,51 @@
+// +build unit
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T) string {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	target := &types.Target{UniqueID: "1", Name: "target", Language: "c", Revision: "abc"}
+	Register(target)
+	SetStats("1", Stats{ExecsPerSecond: 250, TotalExecs: 1000, Crashes: 3, Buckets: 2, CorpusSize: 40})
+	FuzzerStarted("1")
+	FuzzerStarted("1")
+	BuildFinished("1", 2*time.Second)
+	BackupFinished("1", nil)
+	SetContainerState("1", Running)
+
+	labels := `id="1",language="c",name="target",revision="abc"`
+	body := scrape(t)
+	assert.Contains(t, body, `maxfuzz_execs_per_second{`+labels+`} 250`)
+	assert.Contains(t, body, `maxfuzz_execs_total{`+labels+`} 1000`)
+	assert.Contains(t, body, `maxfuzz_crash_buckets{`+labels+`} 2`)
+	assert.Contains(t, body, `maxfuzz_corpus_size{`+labels+`} 40`)
+	assert.Contains(t, body, `maxfuzz_fuzzer_restarts_total{`+labels+`} 1`)
+	assert.Contains(t, body, `maxfuzz_build_duration_seconds{`+labels+`} 2`)
+	assert.Contains(t, body, `maxfuzz_backups_total{id="1",language="c",name="target",result="success",revision="abc"} 1`)
+	assert.Contains(t, body, `maxfuzz_container_state{`+labels+`,state="running"} 1`)
+	assert.Contains(t, body, `maxfuzz_container_state{`+labels+`,state="stopped"} 0`)
+
+	Unregister("1")
+	assert.NotContains(t, scrape(t), `id="1"`)
+}
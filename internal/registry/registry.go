@@ -0,0 +1,288 @@
+package registry
+
+// Persists registered targets, scheduling state and the history of each
+// target's stats to an embedded bolt database, so that maxfuzz can pick
+// up where it left off after a restart.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	targetsBucket   = []byte("targets")
+	timersBucket    = []byte("timers")
+	fuzzTimeBucket  = []byte("fuzz_time")
+	pausedBucket    = []byte("paused")
+	lifecycleBucket = []byte("lifecycles")
+	statsBucket     = []byte("stats") // Holds a bucket of samples per target
+)
+
+type Registry struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the registry database at the given path
+func Open(path string) (*Registry, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot make registry directory: %s", err.Error())
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open registry: %s", err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{targetsBucket, timersBucket, fuzzTimeBucket, pausedBucket, lifecycleBucket, statsBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Cannot initialize registry: %s", err.Error())
+	}
+	return &Registry{db}, nil
+}
+
+func (r *Registry) Close() error {
+	return r.db.Close()
+}
+
+func (r *Registry) PutTarget(t *types.Target) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(targetsBucket).Put([]byte(t.UniqueID), data)
+	})
+}
+
+// DeleteTarget removes a target along with its scheduling state and stats
+// history
+func (r *Registry) DeleteTarget(id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(targetsBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(timersBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(fuzzTimeBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(pausedBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(lifecycleBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(statsBucket).DeleteBucket([]byte(id))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (r *Registry) Targets() ([]*types.Target, error) {
+	toReturn := []*types.Target{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(targetsBucket).ForEach(func(k, v []byte) error {
+			t := types.Target{}
+			err := json.Unmarshal(v, &t)
+			if err != nil {
+				return fmt.Errorf("Corrupt registry entry for target %s: %s", k, err.Error())
+			}
+			toReturn = append(toReturn, &t)
+			return nil
+		})
+	})
+	return toReturn, err
+}
+
+func (r *Registry) putInt(bucket []byte, id string, v int64) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(id), []byte(strconv.FormatInt(v, 10)))
+	})
+}
+
+func (r *Registry) ints(bucket []byte) (map[string]int64, error) {
+	toReturn := map[string]int64{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			i, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Corrupt registry %s entry for target %s: %s", bucket, k, err.Error())
+			}
+			toReturn[string(k)] = i
+			return nil
+		})
+	})
+	return toReturn, err
+}
+
+// PutTimer records the last time a target finished a scheduling slot
+func (r *Registry) PutTimer(id string, lastRun int64) error {
+	return r.putInt(timersBucket, id, lastRun)
+}
+
+func (r *Registry) Timers() (map[string]int64, error) {
+	return r.ints(timersBucket)
+}
+
+// PutFuzzTime records the total number of seconds a target has been fuzzed
+// for
+func (r *Registry) PutFuzzTime(id string, seconds int64) error {
+	return r.putInt(fuzzTimeBucket, id, seconds)
+}
+
+func (r *Registry) FuzzTimes() (map[string]int64, error) {
+	return r.ints(fuzzTimeBucket)
+}
+
+// PutPaused records when a target was paused, so that it stays paused across
+// restarts until it is resumed
+func (r *Registry) PutPaused(id string, since int64) error {
+	return r.putInt(pausedBucket, id, since)
+}
+
+func (r *Registry) DeletePaused(id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pausedBucket).Delete([]byte(id))
+	})
+}
+
+func (r *Registry) Paused() (map[string]int64, error) {
+	return r.ints(pausedBucket)
+}
+
+// PutLifecycle records where a target is in its lifecycle, kept as JSON like
+// stats samples. Lifecycles of targets that aren't registered are dropped.
+func (r *Registry) PutLifecycle(id string, lifecycle interface{}) error {
+	data, err := json.Marshal(lifecycle)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(targetsBucket).Get([]byte(id)) == nil {
+			return nil
+		}
+		return tx.Bucket(lifecycleBucket).Put([]byte(id), data)
+	})
+}
+
+func (r *Registry) Lifecycles() (map[string]json.RawMessage, error) {
+	toReturn := map[string]json.RawMessage{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(lifecycleBucket).ForEach(func(k, v []byte) error {
+			toReturn[string(k)] = append(json.RawMessage{}, v...)
+			return nil
+		})
+	})
+	return toReturn, err
+}
+
+// StatsSample is a target's stats at a point in time. The stats are kept as
+// JSON so that the registry doesn't depend on the fuzzer services.
+type StatsSample struct {
+	Time  int64           `json:"time"`
+	Stats json.RawMessage `json:"stats"`
+}
+
+func sampleKey(t int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t))
+	return key
+}
+
+// PutStats appends a sample to a target's stats history, dropping the oldest
+// samples once there are more than limit. Samples of targets that aren't
+// registered, e.g. because they were just deleted, are dropped.
+func (r *Registry) PutStats(id string, t int64, stats interface{}, limit int) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(targetsBucket).Get([]byte(id)) == nil {
+			return nil
+		}
+		b, err := tx.Bucket(statsBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		// The bucket's sequence counts its samples
+		count := b.Sequence()
+		key := sampleKey(t)
+		existed := b.Get(key) != nil
+		err = b.Put(key, data)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		if count == 0 {
+			// New histories, or recorded before samples were counted
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				count++
+			}
+		} else if !existed {
+			count++
+		}
+		for ; count > uint64(limit); count-- {
+			c.First()
+			err = c.Delete()
+			if err != nil {
+				return err
+			}
+		}
+		return b.SetSequence(count)
+	})
+}
+
+// StatsHistory returns a target's stats samples from since onwards, oldest
+// first. A positive step downsamples the history to the last sample of every
+// step seconds.
+func (r *Registry) StatsHistory(id string, since, step int64) ([]StatsSample, error) {
+	toReturn := []StatsSample{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(statsBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(sampleKey(since)); k != nil; k, v = c.Next() {
+			sample := StatsSample{
+				Time: int64(binary.BigEndian.Uint64(k)),
+				// v is only valid during the transaction
+				Stats: append(json.RawMessage{}, v...),
+			}
+			last := len(toReturn) - 1
+			if step > 0 && last >= 0 && toReturn[last].Time/step == sample.Time/step {
+				toReturn[last] = sample
+				continue
+			}
+			toReturn = append(toReturn, sample)
+		}
+		return nil
+	})
+	return toReturn, err
+}
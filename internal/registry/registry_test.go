@@ -0,0 +1,110 @@
+// +build unit
+
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryPersistsAcrossReopen(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "registry.db")
+
+	r, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, r.PutTarget(&types.Target{Name: "one", UniqueID: "1", Language: "c"}))
+	assert.Nil(t, r.PutTarget(&types.Target{Name: "two", UniqueID: "2", Language: "go"}))
+	assert.Nil(t, r.PutTimer("1", 1234))
+	assert.Nil(t, r.PutTimer("2", 5678))
+	assert.Nil(t, r.PutPaused("1", 1000))
+	assert.Nil(t, r.PutPaused("2", 2000))
+	assert.Nil(t, r.PutLifecycle("1", map[string]string{"state": "FAILED"}))
+	assert.Nil(t, r.PutLifecycle("2", map[string]string{"state": "FUZZING"}))
+	assert.Nil(t, r.PutLifecycle("3", map[string]string{"state": "FUZZING"}))
+	assert.Nil(t, r.DeleteTarget("2"))
+	assert.Nil(t, r.Close())
+
+	r, err = Open(path)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	targets, err := r.Targets()
+	assert.Nil(t, err)
+	assert.Equal(t, []*types.Target{{Name: "one", UniqueID: "1", Language: "c"}}, targets)
+
+	timers, err := r.Timers()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"1": 1234}, timers)
+
+	paused, err := r.Paused()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"1": 1000}, paused)
+	assert.Nil(t, r.DeletePaused("1"))
+	paused, err = r.Paused()
+	assert.Nil(t, err)
+	assert.Empty(t, paused)
+
+	// Only registered targets' lifecycles are kept
+	lifecycles, err := r.Lifecycles()
+	assert.Nil(t, err)
+	assert.Len(t, lifecycles, 1)
+	assert.JSONEq(t, `{"state":"FAILED"}`, string(lifecycles["1"]))
+}
+
+func TestStatsHistory(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	r, err := Open(filepath.Join(tmp, "registry.db"))
+	assert.Nil(t, err)
+	defer r.Close()
+
+	// Only registered targets have a history
+	assert.Nil(t, r.PutStats("1", 90, map[string]int64{"crashes": 0}, 5))
+	history, err := r.StatsHistory("1", 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+
+	assert.Nil(t, r.PutTarget(&types.Target{UniqueID: "1"}))
+	for i := int64(0); i < 6; i++ {
+		assert.Nil(t, r.PutStats("1", 100+i*30, map[string]int64{"crashes": i}, 5))
+	}
+
+	// The oldest sample was dropped
+	history, err = r.StatsHistory("1", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(history))
+	assert.Equal(t, int64(130), history[0].Time)
+	assert.JSONEq(t, `{"crashes": 1}`, string(history[0].Stats))
+
+	history, err = r.StatsHistory("1", 160, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(history))
+
+	// The last sample of every minute
+	history, err = r.StatsHistory("1", 0, 60)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, []int64{160, 220, 250}, []int64{history[0].Time, history[1].Time, history[2].Time})
+
+	// Lowering the limit drops every excess sample
+	assert.Nil(t, r.PutStats("1", 280, map[string]int64{"crashes": 6}, 3))
+	history, err = r.StatsHistory("1", 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{220, 250, 280}, []int64{history[0].Time, history[1].Time, history[2].Time})
+
+	assert.Nil(t, r.DeleteTarget("1"))
+	history, err = r.StatsHistory("1", 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+}
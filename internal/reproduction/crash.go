@@ -4,6 +4,9 @@ package reproduction
 // reproduce.
 
 type Crash struct {
-	Filename string
-	Kind     string
+	Filename  string // Location of the crash input on the host
+	Kind      string
+	Target    string // Target ID
+	PayloadID string // ID returned by StorageHandler.SavePayload
+	Revision  string // Revision of the target the crash was found in
 }
@@ -0,0 +1,73 @@
+package reproduction
+
+// Defines the queue that crash services publish crashes to, and that the
+// reproduction service consumes from.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = &logrus.Logger{
+	Out:       os.Stderr,
+	Formatter: new(logrus.JSONFormatter),
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.DebugLevel,
+}
+
+type Queue interface {
+	Produce(Crash) error
+	// Consume calls handler for every crash on the queue until stop fires
+	Consume(stop chan bool, handler func(Crash))
+}
+
+// NewQueue opens the reproduction queue for a target. An in-process queue is
+// used unless the rmq queue is configured, in which case the configured Redis
+// instance is used.
+func NewQueue(name string) Queue {
+	reproductionConfig := config.Get().Reproduction
+	if reproductionConfig.Queue == "rmq" {
+		return NewRmqQueue(reproductionConfig.RedisURL, name)
+	}
+	return NewMemoryQueue()
+}
+
+type memoryQueue struct {
+	crashes chan Crash
+	lock    sync.Mutex
+}
+
+func NewMemoryQueue() Queue {
+	return &memoryQueue{
+		crashes: make(chan Crash, 1024),
+	}
+}
+
+func (q *memoryQueue) Produce(c Crash) error {
+	select {
+	case q.crashes <- c:
+		return nil
+	default:
+		return fmt.Errorf("Reproduction queue full, dropping crash %s", c.Filename)
+	}
+}
+
+func (q *memoryQueue) Consume(stop chan bool, handler func(Crash)) {
+	// Only one consumer at a time, so that a restarted consumer doesn't race
+	// the one it replaces
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		select {
+		case c := <-q.crashes:
+			handler(c)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package reproduction
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/docker"
+
+	"github.com/subosito/gotenv"
+)
+
+var reproductionTimeout = 60 * time.Second
+
+// reproductionCommand works out how to run a single input against the target.
+// REPRODUCE_COMMAND takes precedence over AFL_BINARY and LIBFUZZER_BINARY, and
+// "@@" is replaced with the input location, as with afl-fuzz. Commands without
+// "@@" read the input from stdin through the reproduce_stdin script. libFuzzer
+// binaries run the inputs they are given as arguments.
+func reproductionCommand(env map[string]string) ([]string, error) {
+	command, ok := env["REPRODUCE_COMMAND"]
+	if !ok {
+		command, ok = env["AFL_BINARY"]
+	}
+	if !ok {
+		command, ok = env["LIBFUZZER_BINARY"]
+		command += " @@"
+	}
+	if !ok || strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("None of REPRODUCE_COMMAND, AFL_BINARY or LIBFUZZER_BINARY populated in environment")
+	}
+
+	fields := strings.Fields(command)
+	fileInput := false
+	for i, f := range fields {
+		if f == "@@" {
+			fields[i] = constants.ReproductionInput
+			fileInput = true
+		}
+	}
+	if fileInput {
+		return fields, nil
+	}
+	return []string{constants.ReproduceStdin, strings.Join(fields, " ")}, nil
+}
+
+// Reproduce runs a crash against the committed image of the target that
+// produced it
+func Reproduce(c Crash) (*docker.Reproduction, error) {
+	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, c.Target, "environment"))
+	if err != nil {
+		return nil, err
+	}
+	environment := gotenv.Parse(environmentFile)
+	environmentFile.Close()
+
+	command, err := reproductionCommand(environment)
+	if err != nil {
+		return nil, err
+	}
+
+	return docker.Reproduce(c.Target, command, c.Filename, reproductionTimeout)
+}
@@ -0,0 +1,45 @@
+// +build unit
+
+package reproduction
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReproductionCommand(t *testing.T) {
+	_, err := reproductionCommand(map[string]string{})
+	assert.NotNil(t, err)
+
+	command, err := reproductionCommand(map[string]string{"AFL_BINARY": "/root/fuzzer/target -x"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/usr/local/bin/reproduce_stdin", "/root/fuzzer/target -x"}, command)
+
+	command, err = reproductionCommand(map[string]string{
+		"AFL_BINARY":        "/root/fuzzer/target @@",
+		"REPRODUCE_COMMAND": "/root/fuzzer/repro --file @@",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/root/fuzzer/repro", "--file", "/root/fuzz_in/input"}, command)
+
+	command, err = reproductionCommand(map[string]string{"LIBFUZZER_BINARY": "/root/fuzzer/target"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/root/fuzzer/target", "/root/fuzz_in/input"}, command)
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue()
+	assert.Nil(t, q.Produce(Crash{Filename: "one"}))
+	assert.Nil(t, q.Produce(Crash{Filename: "two"}))
+
+	stop := make(chan bool)
+	received := []string{}
+	q.Consume(stop, func(c Crash) {
+		received = append(received, c.Filename)
+		if len(received) == 2 {
+			go func() { stop <- true }()
+		}
+	})
+	assert.Equal(t, []string{"one", "two"}, received)
+}
@@ -0,0 +1,64 @@
+package reproduction
+
+// Defines methods for opening up a connection to a Redis Queue, and sending
+// crash definitions (crash.go) so a crash reproducer can pick them up.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/adjust/rmq"
+	"github.com/sirupsen/logrus"
+)
+
+type rmqQueue struct {
+	queue rmq.Queue
+}
+
+type rmqConsumer struct {
+	handler func(Crash)
+}
+
+func (c rmqConsumer) Consume(delivery rmq.Delivery) {
+	crash := Crash{}
+	err := json.Unmarshal([]byte(delivery.Payload()), &crash)
+	if err != nil {
+		log.WithFields(
+			logrus.Fields{"message": fmt.Sprintf("Could not unmarshal Crash struct from json: %v", err)},
+		).Error()
+		delivery.Reject()
+		return
+	}
+	c.handler(crash)
+	delivery.Ack()
+}
+
+func NewRmqQueue(redisUrl, fuzzerName string) Queue {
+	var connection rmq.Connection
+	if os.Getenv("MAXFUZZ_ENV") == "test" || os.Getenv("NO_REPRODUCTION") == "1" {
+		connection = rmq.NewTestConnection()
+	} else {
+		connection = rmq.OpenConnection("crash stream", "tcp", redisUrl, 1)
+	}
+	return rmqQueue{connection.OpenQueue(fuzzerName)}
+}
+
+func (q rmqQueue) Produce(c Crash) error {
+	crashBytes, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("Could not marshal Crash struct into json: %v", err)
+	}
+	if !q.queue.PublishBytes(crashBytes) {
+		return fmt.Errorf("Could not publish crash %s", c.Filename)
+	}
+	return nil
+}
+
+func (q rmqQueue) Consume(stop chan bool, handler func(Crash)) {
+	q.queue.StartConsuming(1, time.Second)
+	q.queue.AddConsumer("reproducer", rmqConsumer{handler})
+	<-stop
+	q.queue.StopConsuming()
+}
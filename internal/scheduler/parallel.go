@@ -0,0 +1,313 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+// Parallel fuzzes every target from registration until removal, within a
+// budget of cores. Each target is pinned to the cores it reserves, and targets
+// that don't fit wait in a queue until cores are freed. With several docker
+// hosts the budget covers all of them, and targets aren't pinned since the
+// cores they reserve aren't those of the host they are placed on. Targets
+// fuzzed on workers are pinned and queued by the workers themselves, so the
+// coordinator's budget grows to fit every target unless cores is set.
+type Parallel struct {
+	lock     sync.Mutex
+	fuzzers  Fuzzers
+	logger   logging.Logger
+	cores    []string // ID of the target pinned to each core, "" if free
+	pin      bool
+	elastic  bool
+	pending  []*types.Target
+	targets  map[string]*types.Target
+	paused   map[string]bool
+	stopping map[string]bool // Targets whose cores are released once they are stopped
+}
+
+func NewParallel(f Fuzzers, l logging.Logger) *Parallel {
+	c := config.Get()
+	cores := c.Cores
+	elastic := cores == 0 && c.Cluster.Workers
+	if cores == 0 {
+		cores = docker.Capacity()
+	}
+	if cores == 0 {
+		cores = runtime.NumCPU()
+	}
+	return &Parallel{
+		fuzzers:  f,
+		logger:   l,
+		cores:    make([]string, cores),
+		pin:      !docker.MultiHost() && !c.Cluster.Workers,
+		elastic:  elastic,
+		pending:  []*types.Target{},
+		targets:  map[string]*types.Target{},
+		paused:   map[string]bool{},
+		stopping: map[string]bool{},
+	}
+}
+
+// CoresNeeded is the number of cores a target reserves, one per fuzzer
+// instance unless it asks for more or less
+func CoresNeeded(t *types.Target) int {
+	toReturn := t.Cores
+	if toReturn < 1 {
+		toReturn = t.Instances
+	}
+	if toReturn < 1 {
+		toReturn = 1
+	}
+	return toReturn
+}
+
+// coresNeeded limits CoresNeeded to the budget
+func (p *Parallel) coresNeeded(t *types.Target) int {
+	toReturn := CoresNeeded(t)
+	if toReturn > len(p.cores) && !p.elastic {
+		p.logger.Error(fmt.Sprintf("Target %s needs %d cores but only %d are available, limiting it to them", t.UniqueID, toReturn, len(p.cores)))
+		toReturn = len(p.cores)
+	}
+	return toReturn
+}
+
+// Cores is the size of the budget
+func (p *Parallel) Cores() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.cores)
+}
+
+// reserve pins a target to n free cores, returning its cpuset, or "" if
+// there aren't enough free cores
+func (p *Parallel) reserve(id string, n int) string {
+	free := []int{}
+	for i, owner := range p.cores {
+		if owner == "" && len(free) < n {
+			free = append(free, i)
+		}
+	}
+	for p.elastic && len(free) < n {
+		p.cores = append(p.cores, "")
+		free = append(free, len(p.cores)-1)
+	}
+	if len(free) < n {
+		return ""
+	}
+	cpuset := []string{}
+	for _, i := range free {
+		p.cores[i] = id
+		cpuset = append(cpuset, strconv.Itoa(i))
+	}
+	return strings.Join(cpuset, ",")
+}
+
+func (p *Parallel) release(id string) bool {
+	toReturn := false
+	for i, owner := range p.cores {
+		if owner == id {
+			p.cores[i] = ""
+			toReturn = true
+		}
+	}
+	return toReturn
+}
+
+// startPending starts queued targets in order for as long as they fit,
+// skipping paused ones. Must be called with the lock held.
+func (p *Parallel) startPending() {
+	for _, t := range p.waiting() {
+		cpuset := p.reserve(t.UniqueID, p.coresNeeded(t))
+		if cpuset == "" {
+			p.logger.Info(fmt.Sprintf("%d targets pending until cores are free", len(p.pending)))
+			return
+		}
+		p.dequeue(t.UniqueID)
+
+		pinned := *t
+		if p.pin {
+			pinned.Resources.Cpuset = cpuset
+		}
+		err := p.fuzzers.Start(&pinned)
+		if err != nil {
+			// Retried the next time targets are scheduled
+			p.logger.Error(fmt.Sprintf("Could not start target %s, queueing it again: %s", t.UniqueID, err.Error()))
+			p.release(t.UniqueID)
+			p.pending = append(p.pending, t)
+		}
+	}
+}
+
+// waiting returns the queued targets that aren't paused, in order. Targets
+// still being stopped wait until they are down. Must be called with the lock
+// held.
+func (p *Parallel) waiting() []*types.Target {
+	toReturn := []*types.Target{}
+	for _, t := range p.pending {
+		if !p.paused[t.UniqueID] && !p.stopping[t.UniqueID] {
+			toReturn = append(toReturn, t)
+		}
+	}
+	return toReturn
+}
+
+func (p *Parallel) dequeue(id string) {
+	for i, t := range p.pending {
+		if t.UniqueID == id {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// preempt hands the cores of running paused targets over to the first waiting
+// target, for as long as it doesn't fit otherwise. The paused targets are
+// queued again, and returned to be stopped. Their cores stay reserved until
+// they are, see stop. Must be called with the lock held.
+func (p *Parallel) preempt() []string {
+	toStop := []string{}
+	waiting := p.waiting()
+	if len(waiting) == 0 {
+		return toStop
+	}
+	// Cores of targets being stopped are as good as free
+	free := 0
+	for _, owner := range p.cores {
+		if owner == "" || p.stopping[owner] {
+			free++
+		}
+	}
+	frozen := []string{}
+	for id := range p.paused {
+		if p.running(id) && !p.stopping[id] {
+			frozen = append(frozen, id)
+		}
+	}
+	sort.Strings(frozen)
+	needed := p.coresNeeded(waiting[0])
+	for _, id := range frozen {
+		if free >= needed {
+			break
+		}
+		for _, owner := range p.cores {
+			if owner == id {
+				free++
+			}
+		}
+		p.logger.Info(fmt.Sprintf("Target %s is paused, handing its cores over", id))
+		p.stopping[id] = true
+		p.pending = append(p.pending, p.targets[id])
+		toStop = append(toStop, id)
+	}
+	return toStop
+}
+
+// stop stops the fuzzers of targets marked as stopping, then releases their
+// cores and starts the targets that fit on them. Stopping blocks until the
+// fuzzers are down, so must be called without the lock held.
+func (p *Parallel) stop(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		p.fuzzers.Stop(id)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, id := range ids {
+		delete(p.stopping, id)
+		p.release(id)
+	}
+	p.startPending()
+}
+
+// reschedule starts the targets that fit, stopping paused targets to make
+// room for the others
+func (p *Parallel) reschedule() {
+	p.lock.Lock()
+	p.startPending()
+	toStop := p.preempt()
+	p.lock.Unlock()
+	p.stop(toStop)
+}
+
+func (p *Parallel) Add(t *types.Target) {
+	p.lock.Lock()
+	p.pending = append(p.pending, t)
+	p.targets[t.UniqueID] = t
+	p.lock.Unlock()
+	p.reschedule()
+}
+
+// Remove stops a target, keeping its cores reserved until its fuzzer is down.
+// Pending targets are started on them once it is.
+func (p *Parallel) Remove(id string) {
+	p.lock.Lock()
+	toStop := []string{}
+	if p.running(id) && !p.stopping[id] {
+		p.stopping[id] = true
+		toStop = append(toStop, id)
+	}
+	p.dequeue(id)
+	delete(p.targets, id)
+	delete(p.paused, id)
+	p.lock.Unlock()
+	p.stop(toStop)
+	p.reschedule()
+}
+
+func (p *Parallel) Pause(id string) {
+	p.lock.Lock()
+	p.paused[id] = true
+	p.lock.Unlock()
+	p.reschedule()
+}
+
+func (p *Parallel) Resume(id string) {
+	p.lock.Lock()
+	delete(p.paused, id)
+	p.lock.Unlock()
+	p.reschedule()
+}
+
+// running reports whether a target holds cores. Must be called with the lock
+// held.
+func (p *Parallel) running(id string) bool {
+	for _, owner := range p.cores {
+		if owner == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parallel) State(id string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running(id) && !p.stopping[id] {
+		return Running
+	}
+	for _, t := range p.pending {
+		if t.UniqueID == id {
+			return Pending
+		}
+	}
+	return ""
+}
+
+func (p *Parallel) Run() {
+	if p.elastic {
+		p.logger.Info("Fuzzing targets in parallel on workers")
+		return
+	}
+	p.logger.Info(fmt.Sprintf("Fuzzing targets in parallel on %d cores", len(p.cores)))
+}
@@ -0,0 +1,140 @@
+// +build unit
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyFuzzers fails to start targets until started is set, and calls
+// onStop while stopping them
+type flakyFuzzers struct {
+	testFuzzers
+	started bool
+	onStop  func(id string)
+}
+
+func (f *flakyFuzzers) Start(t *types.Target) error {
+	if !f.started {
+		return errors.New("no docker")
+	}
+	return f.testFuzzers.Start(t)
+}
+
+func (f *flakyFuzzers) Stop(id string) {
+	if f.onStop != nil {
+		f.onStop(id)
+	}
+	f.testFuzzers.Stop(id)
+}
+
+func TestParallelCoreBudget(t *testing.T) {
+	c := config.Default()
+	c.Cores = 4
+	config.Set(c)
+	f := testFuzzers{}
+	p := NewParallel(f, logging.NewFuzzerLogger("test"))
+
+	p.Add(&types.Target{UniqueID: "a", Instances: 2})
+	p.Add(&types.Target{UniqueID: "b", Cores: 3})
+	p.Add(&types.Target{UniqueID: "c"})
+	assert.Equal(t, testFuzzers{"a": "0,1"}, f)
+	assert.Equal(t, Running, p.State("a"))
+	assert.Equal(t, Pending, p.State("b"))
+	// Targets start in the order they were added
+	assert.Equal(t, Pending, p.State("c"))
+
+	p.Remove("a")
+	assert.Equal(t, testFuzzers{"b": "0,1,2", "c": "3"}, f)
+
+	p.Add(&types.Target{UniqueID: "d"})
+	p.Remove("d")
+	assert.Equal(t, "", p.State("d"))
+	p.Remove("b")
+	assert.Equal(t, testFuzzers{"c": "3"}, f)
+}
+
+func TestParallelOnWorkers(t *testing.T) {
+	c := config.Default()
+	c.Cluster.Workers = true
+	config.Set(c)
+	f := testFuzzers{}
+	p := NewParallel(f, logging.NewFuzzerLogger("test"))
+
+	// Workers pin targets themselves, and the budget grows to fit them all
+	for _, id := range []string{"a", "b", "c"} {
+		p.Add(&types.Target{UniqueID: id, Cores: p.Cores()})
+	}
+	assert.Equal(t, testFuzzers{"a": "", "b": "", "c": ""}, f)
+}
+
+func TestParallelPause(t *testing.T) {
+	c := config.Default()
+	c.Cores = 2
+	config.Set(c)
+	f := testFuzzers{}
+	p := NewParallel(f, logging.NewFuzzerLogger("test"))
+
+	// Paused targets keep their cores until another target needs them
+	p.Add(&types.Target{UniqueID: "a", Cores: 2})
+	p.Pause("a")
+	assert.Equal(t, testFuzzers{"a": "0,1"}, f)
+	p.Add(&types.Target{UniqueID: "b"})
+	assert.Equal(t, testFuzzers{"b": "0"}, f)
+	assert.Equal(t, Pending, p.State("a"))
+
+	// Paused targets don't start, and resumed ones wait for their cores
+	p.Pause("c")
+	p.Add(&types.Target{UniqueID: "c"})
+	assert.Equal(t, testFuzzers{"b": "0"}, f)
+	p.Resume("a")
+	assert.Equal(t, Pending, p.State("a"))
+	p.Remove("b")
+	assert.Equal(t, testFuzzers{"a": "0,1"}, f)
+}
+
+func TestParallelStartFailure(t *testing.T) {
+	c := config.Default()
+	c.Cores = 2
+	config.Set(c)
+	f := &flakyFuzzers{testFuzzers: testFuzzers{}}
+	p := NewParallel(f, logging.NewFuzzerLogger("test"))
+
+	// Targets that fail to start are queued again, without holding cores
+	p.Add(&types.Target{UniqueID: "a", Cores: 2})
+	assert.Equal(t, Pending, p.State("a"))
+	assert.Equal(t, []string{"", ""}, p.cores)
+
+	f.started = true
+	p.Add(&types.Target{UniqueID: "b"})
+	assert.Equal(t, testFuzzers{"a": "0,1"}, f.testFuzzers)
+	assert.Equal(t, Running, p.State("a"))
+	assert.Equal(t, Pending, p.State("b"))
+}
+
+func TestParallelStopping(t *testing.T) {
+	c := config.Default()
+	c.Cores = 2
+	config.Set(c)
+	f := &flakyFuzzers{testFuzzers: testFuzzers{}, started: true}
+	p := NewParallel(f, logging.NewFuzzerLogger("test"))
+	p.Add(&types.Target{UniqueID: "a", Cores: 2})
+
+	// Cores stay reserved until the fuzzer using them is down
+	f.onStop = func(id string) {
+		f.onStop = nil
+		p.Add(&types.Target{UniqueID: "b"})
+		assert.Equal(t, testFuzzers{"a": "0,1"}, f.testFuzzers)
+		assert.Equal(t, "", p.State("a"))
+		assert.Equal(t, Pending, p.State("b"))
+	}
+	p.Remove("a")
+	assert.Equal(t, testFuzzers{"b": "0"}, f.testFuzzers)
+}
@@ -0,0 +1,291 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+// How often running slots are checked
+var checkInterval = time.Minute
+
+type entry struct {
+	target    *types.Target
+	lastRun   int64         // When the target last finished a slot
+	fuzzTime  time.Duration // Total time the target has been fuzzed for
+	running   bool
+	slot      Slot
+	slotStart time.Time
+	stale     *supervisor.TargetStats // Stats from before the target started
+}
+
+func weight(t *types.Target) float64 {
+	if t.Weight > 0 {
+		return float64(t.Weight)
+	}
+	return 1
+}
+
+// leastRecent prefers targets that haven't been fuzzed for the longest
+func leastRecent(a, b *entry) bool {
+	if a.lastRun != b.lastRun {
+		return a.lastRun < b.lastRun
+	}
+	return a.target.UniqueID < b.target.UniqueID
+}
+
+// fairShare prefers targets that have been fuzzed the least for their weight
+func fairShare(a, b *entry) bool {
+	shareA := a.fuzzTime.Seconds() / weight(a.target)
+	shareB := b.fuzzTime.Seconds() / weight(b.target)
+	if shareA != shareB {
+		return shareA < shareB
+	}
+	return leastRecent(a, b)
+}
+
+// Rotating fuzzes up to concurrency targets at once, giving each a Slot.
+// Whenever slots finish, the targets whose slots finished and the waiting
+// targets are ordered by less, and the first ones get the free slots.
+type Rotating struct {
+	lock        sync.Mutex
+	entries     map[string]*entry
+	concurrency int
+	less        func(a, b *entry) bool
+	fuzzers     Fuzzers
+	history     History
+	stats       StatsFunc
+	logger      logging.Logger
+	timers      map[string]int64 // Persisted state of targets yet to be added
+	fuzzTimes   map[string]int64
+	paused      map[string]bool
+	wake        chan bool
+}
+
+func newRotating(concurrency int, less func(a, b *entry) bool, f Fuzzers, h History, stats StatsFunc, l logging.Logger) (*Rotating, error) {
+	timers, err := h.Timers()
+	if err != nil {
+		return nil, fmt.Errorf("Could not load target timers: %s", err.Error())
+	}
+	fuzzTimes, err := h.FuzzTimes()
+	if err != nil {
+		return nil, fmt.Errorf("Could not load target fuzz times: %s", err.Error())
+	}
+	return &Rotating{
+		entries:     map[string]*entry{},
+		concurrency: concurrency,
+		less:        less,
+		fuzzers:     f,
+		history:     h,
+		stats:       stats,
+		logger:      l,
+		timers:      timers,
+		fuzzTimes:   fuzzTimes,
+		paused:      map[string]bool{},
+		wake:        make(chan bool, 1),
+	}, nil
+}
+
+func (r *Rotating) Add(t *types.Target) {
+	r.lock.Lock()
+	fuzzTime, ok := r.fuzzTimes[t.UniqueID]
+	e := &entry{
+		target:   t,
+		lastRun:  r.timers[t.UniqueID],
+		fuzzTime: time.Duration(fuzzTime) * time.Second,
+	}
+	if !ok {
+		// New targets start level with the least fuzzed target, rather than
+		// taking every slot until they catch up with the others
+		e.fuzzTime = time.Duration(r.minimumShare() * weight(t) * float64(time.Second))
+	}
+	r.entries[t.UniqueID] = e
+	r.lock.Unlock()
+	r.notify()
+}
+
+// minimumShare is the least time any target has been fuzzed for its weight,
+// in seconds. Must be called with the lock held.
+func (r *Rotating) minimumShare() float64 {
+	toReturn := -1.0
+	for _, e := range r.entries {
+		share := e.fuzzTime.Seconds() / weight(e.target)
+		if toReturn < 0 || share < toReturn {
+			toReturn = share
+		}
+	}
+	if toReturn < 0 {
+		return 0
+	}
+	return toReturn
+}
+
+func (r *Rotating) Remove(id string) {
+	r.lock.Lock()
+	e, ok := r.entries[id]
+	running := ok && e.running
+	delete(r.entries, id)
+	delete(r.timers, id)
+	delete(r.fuzzTimes, id)
+	delete(r.paused, id)
+	r.lock.Unlock()
+	// Stopping blocks until the fuzzer is down, so isn't done with the lock
+	// held
+	if running {
+		r.fuzzers.Stop(id)
+	}
+	r.notify()
+}
+
+func (r *Rotating) Pause(id string) {
+	r.lock.Lock()
+	r.paused[id] = true
+	r.lock.Unlock()
+	r.notify()
+}
+
+func (r *Rotating) Resume(id string) {
+	r.lock.Lock()
+	delete(r.paused, id)
+	r.lock.Unlock()
+	r.notify()
+}
+
+func (r *Rotating) State(id string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	e, ok := r.entries[id]
+	if !ok {
+		return ""
+	}
+	if e.running {
+		return Running
+	}
+	return Waiting
+}
+
+// notify wakes Run up to fill free slots
+func (r *Rotating) notify() {
+	select {
+	case r.wake <- true:
+	default:
+	}
+}
+
+func (r *Rotating) Run() {
+	r.logger.Info(fmt.Sprintf("Fuzzing up to %d targets at once", r.concurrency))
+	ticker := time.NewTicker(checkInterval)
+	for {
+		r.tick(time.Now())
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// tick schedules targets, then stops the targets whose slots were handed out
+// to others. Stopping blocks until the fuzzer is down, so isn't done with the
+// lock held.
+func (r *Rotating) tick(now time.Time) {
+	r.lock.Lock()
+	stopped := r.schedule(now)
+	r.lock.Unlock()
+	for _, id := range stopped {
+		r.fuzzers.Stop(id)
+	}
+}
+
+// endSlot records the time a target was fuzzed for in its slot. Must be
+// called with the lock held.
+func (r *Rotating) endSlot(e *entry, now time.Time) {
+	id := e.target.UniqueID
+	e.lastRun = now.Unix()
+	e.fuzzTime += now.Sub(e.slotStart)
+	err := r.history.PutTimer(id, e.lastRun)
+	if err == nil {
+		err = r.history.PutFuzzTime(id, int64(e.fuzzTime/time.Second))
+	}
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("Could not persist timer for target %s: %s", id, err.Error()))
+	}
+}
+
+// schedule ends finished slots and hands out free ones, returning the targets
+// to stop. Paused targets keep their slots until others are waiting for
+// them. Must be called with the lock held.
+func (r *Rotating) schedule(now time.Time) []string {
+	candidates := []*entry{}
+	frozen := []*entry{}
+	running := 0
+	for id, e := range r.entries {
+		if r.paused[id] {
+			if e.running {
+				frozen = append(frozen, e)
+			}
+			continue
+		}
+		if !e.running {
+			candidates = append(candidates, e)
+			continue
+		}
+		stats := r.stats(id)
+		if stats == e.stale {
+			stats = nil
+		}
+		if !e.slot.Finished(now.Sub(e.slotStart), stats) {
+			running++
+			continue
+		}
+		r.endSlot(e, now)
+		candidates = append(candidates, e)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return r.less(candidates[i], candidates[j])
+	})
+	sort.Slice(frozen, func(i, j int) bool {
+		return frozen[i].target.UniqueID < frozen[j].target.UniqueID
+	})
+	free := r.concurrency - running - len(frozen)
+	toStop := []string{}
+	for _, e := range frozen {
+		if free >= len(candidates) {
+			break
+		}
+		r.logger.Info(fmt.Sprintf("Target %s is paused, handing its slot over", e.target.UniqueID))
+		r.endSlot(e, now)
+		toStop = append(toStop, e.target.UniqueID)
+		e.running = false
+		free++
+	}
+	for i, e := range candidates {
+		id := e.target.UniqueID
+		if i >= free {
+			if e.running {
+				r.logger.Info(fmt.Sprintf("Slot for target %s finished", id))
+				toStop = append(toStop, id)
+				e.running = false
+			}
+			continue
+		}
+		if !e.running {
+			// Stats only count once they come from this run of the fuzzer
+			e.stale = r.stats(id)
+			err := r.fuzzers.Start(e.target)
+			if err != nil {
+				r.logger.Error(fmt.Sprintf("Could not start target %s: %s", id, err.Error()))
+				continue
+			}
+			e.running = true
+		}
+		e.slot = NewSlot(e.target)
+		e.slotStart = now
+	}
+	return toStop
+}
@@ -0,0 +1,160 @@
+// +build unit
+
+package scheduler
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFuzzers maps running targets to their cpusets
+type testFuzzers map[string]string
+
+func (f testFuzzers) Start(t *types.Target) error {
+	f[t.UniqueID] = t.Resources.Cpuset
+	return nil
+}
+
+func (f testFuzzers) Stop(id string) {
+	delete(f, id)
+}
+
+func (f testFuzzers) running() []string {
+	toReturn := []string{}
+	for id := range f {
+		toReturn = append(toReturn, id)
+	}
+	sort.Strings(toReturn)
+	return toReturn
+}
+
+type testHistory map[string]int64
+
+func (h testHistory) PutTimer(id string, lastRun int64) error    { return nil }
+func (h testHistory) Timers() (map[string]int64, error)          { return map[string]int64{}, nil }
+func (h testHistory) PutFuzzTime(id string, seconds int64) error { h[id] = seconds; return nil }
+func (h testHistory) FuzzTimes() (map[string]int64, error)       { return h, nil }
+func noStats(id string) *supervisor.TargetStats                  { return nil }
+
+// setupRotating returns a scheduler with fixed one hour slots
+func setupRotating(t *testing.T, strategy string, concurrency int, h testHistory, targets ...*types.Target) (*Rotating, testFuzzers) {
+	c := config.Default()
+	c.RobinMinSlot = time.Hour
+	c.RobinMaxSlot = time.Hour
+	c.Concurrency = concurrency
+	config.Set(c)
+
+	f := testFuzzers{}
+	s, err := New(strategy, f, h, noStats, logging.NewFuzzerLogger("test"))
+	assert.Nil(t, err)
+	r := s.(*Rotating)
+	for _, target := range targets {
+		r.Add(target)
+	}
+	return r, f
+}
+
+func TestRoundRobin(t *testing.T) {
+	r, f := setupRotating(t, "robin", 1, testHistory{},
+		&types.Target{UniqueID: "a"}, &types.Target{UniqueID: "b"})
+	start := time.Unix(1000000, 0)
+
+	r.tick(start)
+	assert.Equal(t, []string{"a"}, f.running())
+	r.tick(start.Add(30 * time.Minute))
+	assert.Equal(t, []string{"a"}, f.running())
+	r.tick(start.Add(time.Hour))
+	assert.Equal(t, []string{"b"}, f.running())
+	r.tick(start.Add(2 * time.Hour))
+	assert.Equal(t, []string{"a"}, f.running())
+
+	r.Remove("a")
+	r.tick(start.Add(2 * time.Hour))
+	assert.Equal(t, []string{"b"}, f.running())
+	// A lone target keeps being fuzzed
+	r.tick(start.Add(3 * time.Hour))
+	assert.Equal(t, []string{"b"}, f.running())
+}
+
+func TestWeighted(t *testing.T) {
+	h := testHistory{}
+	r, f := setupRotating(t, "weighted", 1, h,
+		&types.Target{UniqueID: "critical", Weight: 3}, &types.Target{UniqueID: "utility"})
+	start := time.Unix(1000000, 0)
+
+	slots := map[string]int{}
+	for i := 0; i < 8; i++ {
+		r.tick(start.Add(time.Duration(i) * time.Hour))
+		for _, id := range f.running() {
+			slots[id]++
+		}
+	}
+	assert.Equal(t, map[string]int{"critical": 6, "utility": 2}, slots)
+	assert.Equal(t, int64(5*3600), h["critical"])
+	assert.Equal(t, int64(2*3600), h["utility"])
+}
+
+func TestHybrid(t *testing.T) {
+	// Persisted fuzz times carry over, and c starts level with b
+	r, f := setupRotating(t, "hybrid", 2, testHistory{"a": 7200, "b": 0},
+		&types.Target{UniqueID: "a"}, &types.Target{UniqueID: "b"}, &types.Target{UniqueID: "c"})
+	start := time.Unix(1000000, 0)
+
+	r.tick(start)
+	assert.Equal(t, []string{"b", "c"}, f.running())
+	r.tick(start.Add(time.Hour))
+	assert.Equal(t, []string{"b", "c"}, f.running())
+	r.tick(start.Add(2 * time.Hour))
+	assert.Equal(t, []string{"a", "b"}, f.running())
+}
+
+func TestWeightedNewTarget(t *testing.T) {
+	r, f := setupRotating(t, "weighted", 1, testHistory{},
+		&types.Target{UniqueID: "a"}, &types.Target{UniqueID: "b"})
+	start := time.Unix(1000000, 0)
+	for i := 0; i < 4; i++ {
+		r.tick(start.Add(time.Duration(i) * time.Hour))
+	}
+
+	// The new target shares slots with the others rather than catching up on
+	// the hours they were fuzzed for
+	r.Add(&types.Target{UniqueID: "c"})
+	slots := map[string]int{}
+	for i := 4; i < 7; i++ {
+		r.tick(start.Add(time.Duration(i) * time.Hour))
+		for _, id := range f.running() {
+			slots[id]++
+		}
+	}
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, slots)
+}
+
+func TestRotatingPause(t *testing.T) {
+	r, f := setupRotating(t, "robin", 1, testHistory{}, &types.Target{UniqueID: "a"})
+	start := time.Unix(1000000, 0)
+	r.tick(start)
+	assert.Equal(t, []string{"a"}, f.running())
+
+	// Paused targets stay frozen in their slot until another target needs it
+	r.Pause("a")
+	r.tick(start.Add(time.Minute))
+	assert.Equal(t, []string{"a"}, f.running())
+	r.Add(&types.Target{UniqueID: "b"})
+	r.tick(start.Add(2 * time.Minute))
+	assert.Equal(t, []string{"b"}, f.running())
+	assert.Equal(t, Waiting, r.State("a"))
+
+	r.Resume("a")
+	r.tick(start.Add(30 * time.Minute))
+	assert.Equal(t, []string{"b"}, f.running())
+	r.tick(start.Add(time.Hour + 2*time.Minute))
+	assert.Equal(t, []string{"a"}, f.running())
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+// Scheduling states of targets
+const (
+	Running = "RUNNING"
+	Pending = "PENDING" // Waiting for cores to be freed
+	Waiting = "WAITING" // Waiting for a slot in the rotation
+)
+
+// A Scheduler decides when registered targets are fuzzed
+type Scheduler interface {
+	Add(t *types.Target)
+	Remove(id string)
+	// Paused targets aren't started, and running ones keep their fuzzers
+	// frozen until their capacity is needed by other targets, when they are
+	// stopped and queued again
+	Pause(id string)
+	Resume(id string)
+	State(id string) string
+	// Run schedules targets until the process exits, unless the scheduler
+	// starts targets as they are added
+	Run()
+}
+
+// Fuzzers starts and stops the fuzzers of targets
+type Fuzzers interface {
+	Start(t *types.Target) error
+	Stop(id string)
+}
+
+// History persists when each target last finished a slot, and how long it has
+// been fuzzed for in total, so that rotation carries on across restarts
+type History interface {
+	PutTimer(id string, lastRun int64) error
+	Timers() (map[string]int64, error)
+	PutFuzzTime(id string, seconds int64) error
+	FuzzTimes() (map[string]int64, error)
+}
+
+// StatsFunc returns the latest stats of a target
+type StatsFunc func(id string) *supervisor.TargetStats
+
+// New returns the scheduler for a strategy:
+//
+//	parallel: every target is fuzzed at once, within config.Cores
+//	robin:    one target at a time, least recently fuzzed first
+//	weighted: one target at a time, sharing time in proportion to weights
+//	hybrid:   as weighted, but fuzzing config.Concurrency targets at once
+func New(strategy string, f Fuzzers, h History, stats StatsFunc, l logging.Logger) (Scheduler, error) {
+	switch strategy {
+	case "parallel":
+		return NewParallel(f, l), nil
+	case "robin":
+		return newRotating(1, leastRecent, f, h, stats, l)
+	case "weighted":
+		return newRotating(1, fairShare, f, h, stats, l)
+	case "hybrid":
+		return newRotating(config.Get().Concurrency, fairShare, f, h, stats, l)
+	default:
+		return nil, fmt.Errorf("Unsupported fuzz strategy %s", strategy)
+	}
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+// Decides how long targets are fuzzed for when fuzzing round robin. A slot is
+// extended while the target keeps finding new paths, and cut short once its
+// coverage has plateaued.
+
+import (
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+)
+
+type Slot struct {
+	Min           time.Duration
+	Max           time.Duration
+	Base          time.Duration // Slot length when there are no stats to judge progress by
+	PlateauWindow time.Duration // 0 always uses Base
+}
+
+// NewSlot returns the slot of a target, using the configured defaults for
+// bounds the target doesn't set
+func NewSlot(t *types.Target) Slot {
+	c := config.Get()
+	toReturn := Slot{
+		Min:           c.RobinMinSlot,
+		Max:           c.RobinMaxSlot,
+		Base:          c.RobinInterval,
+		PlateauWindow: c.PlateauWindow,
+	}
+	if t.MinSlot > 0 {
+		toReturn.Min = time.Duration(t.MinSlot) * time.Second
+	}
+	if t.MaxSlot > 0 {
+		toReturn.Max = time.Duration(t.MaxSlot) * time.Second
+	}
+	if toReturn.Max < toReturn.Min {
+		toReturn.Max = toReturn.Min
+	}
+	if toReturn.Base < toReturn.Min {
+		toReturn.Base = toReturn.Min
+	}
+	if toReturn.Base > toReturn.Max {
+		toReturn.Base = toReturn.Max
+	}
+	return toReturn
+}
+
+// Finished reports whether a slot that has lasted for elapsed is over. stats
+// are the target's latest stats from this slot, or nil if it hasn't reported
+// any yet.
+func (s Slot) Finished(elapsed time.Duration, stats *supervisor.TargetStats) bool {
+	if elapsed < s.Min {
+		return false
+	}
+	if elapsed >= s.Max {
+		return true
+	}
+	if s.PlateauWindow == 0 || stats == nil {
+		return elapsed >= s.Base
+	}
+	return time.Duration(stats.SinceLastPath)*time.Second >= s.PlateauWindow
+}
@@ -0,0 +1,54 @@
+// +build unit
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/supervisor"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSlot(t *testing.T) {
+	c := config.Default()
+	c.RobinMinSlot = time.Hour
+	c.RobinMaxSlot = 4 * time.Hour
+	c.RobinInterval = 2 * time.Hour
+	config.Set(c)
+
+	assert.Equal(t, Slot{time.Hour, 4 * time.Hour, 2 * time.Hour, c.PlateauWindow}, NewSlot(&types.Target{}))
+
+	// Overrides keep the slot consistent
+	slot := NewSlot(&types.Target{MinSlot: 3 * 3600, MaxSlot: 60})
+	assert.Equal(t, 3*time.Hour, slot.Min)
+	assert.Equal(t, 3*time.Hour, slot.Max)
+	assert.Equal(t, 3*time.Hour, slot.Base)
+}
+
+func TestSlotFinished(t *testing.T) {
+	slot := Slot{
+		Min:           time.Hour,
+		Max:           4 * time.Hour,
+		Base:          2 * time.Hour,
+		PlateauWindow: 30 * time.Minute,
+	}
+	productive := &supervisor.TargetStats{SinceLastPath: 60}
+	plateaued := &supervisor.TargetStats{SinceLastPath: 3600}
+
+	assert.False(t, slot.Finished(30*time.Minute, plateaued))
+	assert.True(t, slot.Finished(90*time.Minute, plateaued))
+	assert.False(t, slot.Finished(3*time.Hour, productive))
+	assert.True(t, slot.Finished(4*time.Hour, productive))
+
+	// Without stats the base slot is used
+	assert.False(t, slot.Finished(90*time.Minute, nil))
+	assert.True(t, slot.Finished(2*time.Hour, nil))
+
+	slot.PlateauWindow = 0
+	assert.False(t, slot.Finished(90*time.Minute, plateaued))
+	assert.True(t, slot.Finished(2*time.Hour, productive))
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Files every target bundle has at its root. The bundle may also hold a
+// corpus directory, and the sources the build steps use.
+var bundleFiles = []string{"build_steps", "environment"}
+
+// Engines that can't start without seed inputs in the bundle's corpus
+var corpusEngines = map[string]bool{"afl": true, "libfuzzer": true}
+
+// ValidateBundle checks that a target bundle is a zip archive laid out the way
+// initialFuzzerSetup expects for the engine, and that none of its entries
+// would be extracted outside of the target directory
+func ValidateBundle(location, engine string) error {
+	r, err := zip.OpenReader(location)
+	if err != nil {
+		return fmt.Errorf("Target bundle is not a zip archive: %s", err.Error())
+	}
+	defer r.Close()
+
+	found := map[string]bool{}
+	seeds := 0
+	for _, f := range r.File {
+		name := path.Clean(strings.Replace(f.Name, "\\", "/", -1))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("Target bundle entry %q is outside the bundle", f.Name)
+		}
+		isDir := f.FileInfo().IsDir()
+		for _, required := range bundleFiles {
+			if name == required && isDir {
+				return fmt.Errorf("Target bundle %s must be a file", required)
+			}
+		}
+		if name == "corpus" && !isDir {
+			return fmt.Errorf("Target bundle corpus must be a directory")
+		}
+		if strings.HasPrefix(name, "corpus/") && !isDir {
+			seeds++
+		}
+		found[name] = true
+	}
+	for _, required := range bundleFiles {
+		if !found[required] {
+			return fmt.Errorf("Target bundle is missing %s", required)
+		}
+	}
+	if corpusEngines[engine] && seeds == 0 {
+		return fmt.Errorf("Target bundle corpus must hold at least one input for %s", engine)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// +build unit
+
+package storage
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBundle(t *testing.T, location string, names ...string) {
+	f, err := os.Create(location)
+	assert.Nil(t, err)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, name := range names {
+		_, err = w.Create(name)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+}
+
+func TestValidateBundle(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-bundle")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	bundle := filepath.Join(tmp, "bundle.zip")
+
+	writeBundle(t, bundle, "build_steps", "environment", "corpus/", "corpus/seed", "src/main.c")
+	assert.Nil(t, ValidateBundle(bundle, "afl"))
+
+	writeBundle(t, bundle, "build_steps", "corpus/")
+	assert.EqualError(t, ValidateBundle(bundle, "afl"), "Target bundle is missing environment")
+
+	writeBundle(t, bundle, "build_steps", "environment", "corpus")
+	assert.EqualError(t, ValidateBundle(bundle, "afl"), "Target bundle corpus must be a directory")
+
+	writeBundle(t, bundle, "build_steps", "environment", "../../etc/cron.d/evil")
+	assert.EqualError(t, ValidateBundle(bundle, "afl"), `Target bundle entry "../../etc/cron.d/evil" is outside the bundle`)
+
+	// go-fuzz starts without seeds, AFL and libFuzzer don't
+	writeBundle(t, bundle, "build_steps", "environment", "corpus/")
+	assert.EqualError(t, ValidateBundle(bundle, "libfuzzer"), "Target bundle corpus must hold at least one input for libfuzzer")
+	assert.Nil(t, ValidateBundle(bundle, "go-fuzz"))
+
+	assert.Nil(t, ioutil.WriteFile(bundle, []byte("not a zip"), 0644))
+	assert.NotNil(t, ValidateBundle(bundle, "afl"))
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+)
+
+// CoordinatorStorageHandler is used by workers. Targets, backups and crash
+// payloads go through the coordinator, which keeps them in its own storage
+// under:
+//
+//	/workers/storage/<target>/target
+//	/workers/storage/<target>/backup
+//	/workers/storage/<target>/payloads/<payload id>
+//	/workers/storage/<target>/outputs/<payload id>
+//	/workers/storage/<target>/metadata/<payload id>
+//	/workers/storage/<target>/buckets
+//	/workers/storage/<target>/corpus/<sha256>
+type CoordinatorStorageHandler struct {
+	targetName string
+	url        string
+	token      string
+	client     *http.Client
+}
+
+func initCoordinatorStorage(targetName string, c config.Cluster) (CoordinatorStorageHandler, error) {
+	if c.Coordinator == "" {
+		return CoordinatorStorageHandler{}, fmt.Errorf("No coordinator configured")
+	}
+	return CoordinatorStorageHandler{
+		targetName: targetName,
+		url:        fmt.Sprintf("%s/workers/storage/%s", strings.TrimRight(c.Coordinator, "/"), url.PathEscape(targetName)),
+		token:      c.Token,
+		client:     &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// do sends a request to the coordinator, returning the response if its status
+// is one of expected
+func (h CoordinatorStorageHandler) do(method, path string, body io.Reader, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(constants.ClusterTokenHeader, h.token)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not reach coordinator: %s", err.Error())
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("Coordinator returned %s for %s %s", resp.Status, method, path)
+}
+
+func (h CoordinatorStorageHandler) download(path, destination string) error {
+	resp, err := h.do(http.MethodGet, path, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = os.MkdirAll(filepath.Dir(destination), 0755)
+	if err != nil {
+		return fmt.Errorf("Cannot make directory: %s", err.Error())
+	}
+	f, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("Error writing file: %s", err.Error())
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("Could not download %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+func (h CoordinatorStorageHandler) upload(method, path, source string) (*http.Response, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	defer f.Close()
+	return h.do(method, path, f, http.StatusOK)
+}
+
+func (h CoordinatorStorageHandler) sendJSON(method, path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not marshal %s: %s", path, err.Error())
+	}
+	resp, err := h.do(method, path, bytes.NewReader(data), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (h CoordinatorStorageHandler) GetTarget() (string, error) {
+	destination := filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf(".%s_working.zip", h.targetName))
+	err := h.download("/target", destination)
+	return destination, err
+}
+
+// SaveTarget isn't supported, as targets are registered with the coordinator
+// rather than its workers
+func (h CoordinatorStorageHandler) SaveTarget(source string) (string, error) {
+	return "", fmt.Errorf("Targets can only be uploaded to the coordinator")
+}
+
+func (h CoordinatorStorageHandler) DeleteTarget() error {
+	return fmt.Errorf("Targets can only be uploaded to the coordinator")
+}
+
+func (h CoordinatorStorageHandler) BackupExists() (bool, error) {
+	resp, err := h.do(http.MethodHead, "/backup", nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (h CoordinatorStorageHandler) GetTargetBackupLocation() string {
+	return filepath.Join(constants.LocalSyncDirectory, h.targetName, "backup.zip")
+}
+
+func (h CoordinatorStorageHandler) GetBackup() (string, error) {
+	outDir := h.GetTargetBackupLocation()
+	err := h.download("/backup", outDir)
+	return outDir, err
+}
+
+// MakeBackup keeps the local archive if the upload fails, so that it isn't
+// lost to a transient error
+func (h CoordinatorStorageHandler) MakeBackup() error {
+	source := h.GetTargetBackupLocation()
+	resp, err := h.upload(http.MethodPut, "/backup", source)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	os.Remove(source)
+	return nil
+}
+
+func (h CoordinatorStorageHandler) SavePayload(source FuzzerPayload) (string, error) {
+	// The coordinator's copy is written when it is received
+	found := source.Found
+	if found == 0 {
+		info, err := os.Stat(source.Location)
+		if err != nil {
+			return "", fmt.Errorf("Error reading file: %s", err.Error())
+		}
+		found = info.ModTime().Unix()
+	}
+	query := url.Values{
+		"name":        {filepath.Base(source.Location)},
+		"category":    {source.Category},
+		"revision":    {source.Revision},
+		"target_name": {source.TargetName},
+		"engine":      {source.Engine},
+		"image":       {source.Image},
+		"host":        {source.Host},
+		"instance":    {source.Instance},
+		"found":       {strconv.FormatInt(found, 10)},
+	}
+	resp, err := h.upload(http.MethodPost, "/payloads?"+query.Encode(), source.Location)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	saved := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&saved)
+	return saved.ID, err
+}
+
+func (h CoordinatorStorageHandler) SaveOutput(source FuzzerPayloadOutput) error {
+	return h.sendJSON(http.MethodPost, "/outputs", source)
+}
+
+func (h CoordinatorStorageHandler) ListPayloads() ([]string, error) {
+	toReturn := []string{}
+	resp, err := h.do(http.MethodGet, "/payloads", nil, http.StatusOK)
+	if err != nil {
+		return toReturn, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&toReturn)
+	return toReturn, err
+}
+
+func (h CoordinatorStorageHandler) PayloadExists(payloadID string) (bool, error) {
+	resp, err := h.do(http.MethodHead, "/payloads/"+url.PathEscape(payloadID), nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (h CoordinatorStorageHandler) GetPayload(payloadID, destination string) error {
+	return h.download("/payloads/"+url.PathEscape(payloadID), destination)
+}
+
+func (h CoordinatorStorageHandler) GetOutput(payloadID string) (*FuzzerPayloadOutput, error) {
+	resp, err := h.do(http.MethodGet, "/outputs/"+url.PathEscape(payloadID), nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	toReturn := &FuzzerPayloadOutput{}
+	err = json.NewDecoder(resp.Body).Decode(toReturn)
+	return toReturn, err
+}
+
+func (h CoordinatorStorageHandler) GetMetadata(payloadID string) (*PayloadMetadata, error) {
+	resp, err := h.do(http.MethodGet, "/metadata/"+url.PathEscape(payloadID), nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	toReturn := &PayloadMetadata{}
+	err = json.NewDecoder(resp.Body).Decode(toReturn)
+	return toReturn, err
+}
+
+func (h CoordinatorStorageHandler) SaveMetadata(metadata PayloadMetadata) error {
+	return h.sendJSON(http.MethodPut, "/metadata/"+url.PathEscape(metadata.ID), metadata)
+}
+
+func (h CoordinatorStorageHandler) GetBuckets() (map[string]*CrashBucket, error) {
+	toReturn := map[string]*CrashBucket{}
+	resp, err := h.do(http.MethodGet, "/buckets", nil, http.StatusOK)
+	if err != nil {
+		return toReturn, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&toReturn)
+	return toReturn, err
+}
+
+func (h CoordinatorStorageHandler) SaveBuckets(buckets map[string]*CrashBucket) error {
+	return h.sendJSON(http.MethodPut, "/buckets", buckets)
+}
+
+func (h CoordinatorStorageHandler) ListCorpus() ([]string, error) {
+	toReturn := []string{}
+	resp, err := h.do(http.MethodGet, "/corpus", nil, http.StatusOK)
+	if err != nil {
+		return toReturn, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&toReturn)
+	return toReturn, err
+}
+
+func (h CoordinatorStorageHandler) SaveCorpusEntry(hash, source string) error {
+	resp, err := h.upload(http.MethodPut, "/corpus/"+hash, source)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (h CoordinatorStorageHandler) GetCorpusEntry(hash, destination string) error {
+	return h.download("/corpus/"+hash, destination)
+}
@@ -1,10 +1,10 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/everestmz/maxfuzz/internal/constants"
 
@@ -102,22 +102,181 @@ func (h LocalStorageHandler) MakeBackup() error {
 }
 
 func (h LocalStorageHandler) SavePayload(source FuzzerPayload) (string, error) {
-	payloadID := fmt.Sprintf("%v_%s", time.Now().Unix(), filepath.Base(source.Location))
+	metadata, err := newPayloadMetadata(h.targetName, source)
+	payloadID := metadata.ID
+	if err != nil {
+		return payloadID, err
+	}
 	destination := filepath.Join(h.targetName, payloadID)
-	err := h.filesystemSync(source.Location, destination)
-	return payloadID, err
+	err = h.filesystemSync(source.Location, destination)
+	if err != nil {
+		return payloadID, err
+	}
+	return payloadID, h.SaveMetadata(metadata)
 }
 
-func (h LocalStorageHandler) SaveOutput(source FuzzerPayloadOutput) error {
-	// TODO: save outputs properly
-	// destination := filepath.Join(h.targetName, fmt.Sprintf("%v_%s", time.Now().Unix(), filepath.Base(source)))
-	// err := h.filesystemSync(source, destination)
+func (h LocalStorageHandler) writeJSON(destination string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not marshal %s: %s", filepath.Base(destination), err.Error())
+	}
+	destination = filepath.Join(constants.LocalCrashStorage, destination)
+	err = os.MkdirAll(filepath.Dir(destination), 0744)
+	if err != nil {
+		return fmt.Errorf("Cannot make directory: %s", err.Error())
+	}
+	err = afero.WriteFile(fs, destination, data, 0744)
+	if err != nil {
+		return fmt.Errorf("Error writing file: %s", err.Error())
+	}
 	return nil
 }
 
+func (h LocalStorageHandler) SaveOutput(source FuzzerPayloadOutput) error {
+	return h.writeJSON(filepath.Join(h.targetName, outputName(source.Identifier)), source)
+}
+
+func (h LocalStorageHandler) GetBuckets() (map[string]*CrashBucket, error) {
+	toReturn := map[string]*CrashBucket{}
+	source := filepath.Join(constants.LocalCrashStorage, h.targetName, "buckets.json")
+	exists, err := afero.Exists(fs, source)
+	if err != nil {
+		return toReturn, fmt.Errorf("File existence check fail: %s", err.Error())
+	}
+	if !exists {
+		return toReturn, nil
+	}
+	data, err := afero.ReadFile(fs, source)
+	if err != nil {
+		return toReturn, fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	err = json.Unmarshal(data, &toReturn)
+	return toReturn, err
+}
+
+func (h LocalStorageHandler) SaveBuckets(buckets map[string]*CrashBucket) error {
+	return h.writeJSON(filepath.Join(h.targetName, "buckets.json"), buckets)
+}
+
 func (h LocalStorageHandler) GetTarget() (string, error) {
 	source := filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf("%s.zip", h.targetName))
-	destination := filepath.Join(constants.LocalTargetDirectory, "working_target.zip")
+	// Target IDs can't start with a dot, so this can't be another target
+	destination := filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf(".%s_working.zip", h.targetName))
 	err := h.filesystemDownload(source, destination)
 	return destination, err
 }
+
+func (h LocalStorageHandler) SaveTarget(source string) (string, error) {
+	destination := filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf("%s.zip", h.targetName))
+	err := os.MkdirAll(constants.LocalTargetDirectory, 0755)
+	if err != nil {
+		return destination, fmt.Errorf("Cannot make directory: %s", err.Error())
+	}
+	err = h.filesystemDownload(source, destination)
+	return destination, err
+}
+
+func (h LocalStorageHandler) DeleteTarget() error {
+	err := os.Remove(filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf("%s.zip", h.targetName)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove target bundle: %s", err.Error())
+	}
+	return nil
+}
+
+func (h LocalStorageHandler) ListCorpus() ([]string, error) {
+	toReturn := []string{}
+	directory := filepath.Join(constants.LocalCrashStorage, h.targetName, "corpus")
+	exists, err := afero.DirExists(fs, directory)
+	if err != nil || !exists {
+		return toReturn, err
+	}
+	files, err := afero.ReadDir(fs, directory)
+	if err != nil {
+		return toReturn, fmt.Errorf("Error reading corpus: %s", err.Error())
+	}
+	for _, f := range files {
+		toReturn = append(toReturn, f.Name())
+	}
+	return toReturn, nil
+}
+
+func (h LocalStorageHandler) SaveCorpusEntry(hash, source string) error {
+	return h.filesystemSync(source, filepath.Join(h.targetName, "corpus", hash))
+}
+
+func (h LocalStorageHandler) GetCorpusEntry(hash, destination string) error {
+	source := filepath.Join(constants.LocalCrashStorage, h.targetName, "corpus", hash)
+	return h.filesystemDownload(source, destination)
+}
+
+func (h LocalStorageHandler) ListPayloads() ([]string, error) {
+	toReturn := []string{}
+	directory := filepath.Join(constants.LocalCrashStorage, h.targetName)
+	exists, err := afero.DirExists(fs, directory)
+	if err != nil || !exists {
+		return toReturn, err
+	}
+	files, err := afero.ReadDir(fs, directory)
+	if err != nil {
+		return toReturn, fmt.Errorf("Error reading payloads: %s", err.Error())
+	}
+	for _, f := range files {
+		if !f.IsDir() && IsPayloadID(f.Name()) {
+			toReturn = append(toReturn, f.Name())
+		}
+	}
+	return toReturn, nil
+}
+
+func (h LocalStorageHandler) PayloadExists(payloadID string) (bool, error) {
+	exists, err := afero.Exists(fs, filepath.Join(constants.LocalCrashStorage, h.targetName, payloadID))
+	if err != nil {
+		return false, fmt.Errorf("File existence check fail: %s", err.Error())
+	}
+	return exists, nil
+}
+
+func (h LocalStorageHandler) GetPayload(payloadID, destination string) error {
+	source := filepath.Join(constants.LocalCrashStorage, h.targetName, payloadID)
+	return h.filesystemDownload(source, destination)
+}
+
+// readJSON reads a file written by writeJSON, returning false if there is none
+func (h LocalStorageHandler) readJSON(source string, v interface{}) (bool, error) {
+	source = filepath.Join(constants.LocalCrashStorage, source)
+	exists, err := afero.Exists(fs, source)
+	if err != nil {
+		return false, fmt.Errorf("File existence check fail: %s", err.Error())
+	}
+	if !exists {
+		return false, nil
+	}
+	data, err := afero.ReadFile(fs, source)
+	if err != nil {
+		return false, fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (h LocalStorageHandler) GetOutput(payloadID string) (*FuzzerPayloadOutput, error) {
+	toReturn := &FuzzerPayloadOutput{}
+	exists, err := h.readJSON(filepath.Join(h.targetName, outputName(payloadID)), toReturn)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return toReturn, nil
+}
+
+func (h LocalStorageHandler) GetMetadata(payloadID string) (*PayloadMetadata, error) {
+	toReturn := &PayloadMetadata{}
+	exists, err := h.readJSON(filepath.Join(h.targetName, metadataName(payloadID)), toReturn)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return toReturn, nil
+}
+
+func (h LocalStorageHandler) SaveMetadata(metadata PayloadMetadata) error {
+	return h.writeJSON(filepath.Join(h.targetName, metadataName(metadata.ID)), metadata)
+}
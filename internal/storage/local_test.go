@@ -0,0 +1,118 @@
+// +build unit
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSaveOutput(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-local")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	constants.LocalCrashStorage = tmp
+
+	h, err := initLocalStorage("target")
+	assert.Nil(t, err)
+	output := FuzzerPayloadOutput{
+		Identifier: "1_crash",
+		Output:     []string{"panic: runtime error"},
+		Stderr:     []string{},
+		ExitCode:   2,
+	}
+	assert.Nil(t, h.SaveOutput(output))
+
+	data, err := ioutil.ReadFile(filepath.Join(tmp, "target", "1_crash.output.json"))
+	assert.Nil(t, err)
+	result := FuzzerPayloadOutput{}
+	assert.Nil(t, json.Unmarshal(data, &result))
+	assert.Equal(t, output, result)
+}
+
+func TestPayloadID(t *testing.T) {
+	assert.True(t, IsPayloadID("1540000000_id:000000,sig:11"))
+	assert.False(t, IsPayloadID("1540000000_crash.output.json"))
+	assert.False(t, IsPayloadID("buckets.json"))
+	assert.Equal(t, int64(1540000000), PayloadTime("1540000000_crash"))
+}
+
+func TestLocalPayloadMetadata(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-local")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	constants.LocalCrashStorage = filepath.Join(tmp, "crashes")
+	source := filepath.Join(tmp, "id:000000,sig:11")
+	assert.Nil(t, ioutil.WriteFile(source, []byte("crash"), 0644))
+
+	h, err := initLocalStorage("target")
+	assert.Nil(t, err)
+	payloadID, err := h.SavePayload(FuzzerPayload{
+		Location: source,
+		Category: "segv",
+		Revision: "abc",
+		Engine:   "afl",
+		Instance: "main",
+	})
+	assert.Nil(t, err)
+	metadata, err := h.GetMetadata(payloadID)
+	assert.Nil(t, err)
+	assert.Equal(t, "id:000000,sig:11", metadata.Name)
+	assert.Equal(t, int64(5), metadata.Size)
+	assert.Equal(t, "cdb2e0d0f873ce5326e87cf7dec48de8da3043cfc950a7eba05a059150e873f5", metadata.SHA256)
+	assert.Equal(t, ReproductionPending, metadata.Reproduction)
+	info, err := os.Stat(source)
+	assert.Nil(t, err)
+	assert.Equal(t, info.ModTime().Unix(), metadata.Time)
+	assert.Equal(t, fmt.Sprintf("%d_cdb2e0d0f873_id:000000,sig:11", metadata.Time), payloadID)
+
+	// Instances name their payloads alike
+	other := filepath.Join(tmp, "other", "id:000000,sig:11")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(other), 0755))
+	assert.Nil(t, ioutil.WriteFile(other, []byte("other crash"), 0644))
+	otherID, err := h.SavePayload(FuzzerPayload{Location: other, Instance: "secondary01", Found: 2})
+	assert.Nil(t, err)
+	assert.NotEqual(t, payloadID, otherID)
+	assert.Equal(t, int64(2), PayloadTime(otherID))
+
+	// Payloads saved without metadata are described by their output
+	assert.Nil(t, h.filesystemSync(source, filepath.Join("target", "1_old")))
+	assert.Nil(t, h.SaveOutput(FuzzerPayloadOutput{Identifier: "1_old", Category: "timeout", Revision: "abc"}))
+
+	assert.Nil(t, SetReproduction(h, "target", payloadID, Reproduced, "heap-overflow", "sig"))
+	all, err := QueryPayloads(h, "target", PayloadQuery{Revision: "abc"})
+	assert.Nil(t, err)
+	assert.Len(t, all, 2)
+	assert.Equal(t, payloadID, all[0].ID)
+	assert.Equal(t, "1_old", all[1].ID)
+	assert.Equal(t, "old", all[1].Name)
+
+	matches, err := QueryPayloads(h, "target", PayloadQuery{Category: "heap-overflow", Bucket: "sig"})
+	assert.Nil(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "main", matches[0].Instance)
+	matches, err = QueryPayloads(h, "target", PayloadQuery{Until: 1})
+	assert.Nil(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, Reproduced, matches[0].Reproduction)
+
+	page, err := QueryPayloads(h, "target", PayloadQuery{Revision: "abc", Offset: 1, Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "1_old", page[0].ID)
+
+	exists, err := h.PayloadExists("1_old")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = h.PayloadExists("2_missing")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/helpers"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3StorageHandler stores targets, backups and crash payloads in an S3
+// bucket (or any S3-compatible object store, such as MinIO). Objects are laid
+// out under the configured prefix as:
+//
+//	<prefix>/targets/<target>.zip
+//	<prefix>/crashes/<target>/backup.zip
+//	<prefix>/crashes/<target>/<payload id>
+//	<prefix>/crashes/<target>/<payload id>.output.json
+//	<prefix>/crashes/<target>/<payload id>.metadata.json
+//	<prefix>/crashes/<target>/corpus/<sha256>
+type S3StorageHandler struct {
+	targetName string
+	bucket     string
+	prefix     string
+	client     *s3.S3
+	uploader   *s3manager.Uploader
+}
+
+func initS3Storage(targetName string, c config.S3) (S3StorageHandler, error) {
+	if c.Bucket == "" {
+		return S3StorageHandler{}, fmt.Errorf("No S3 bucket configured")
+	}
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(c.PathStyle),
+	}
+	// The endpoint can also be passed through the environment
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = helpers.Getenv("S3_ENDPOINT", "")
+	}
+	if endpoint != "" {
+		awsConfig.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return S3StorageHandler{}, fmt.Errorf("Could not create S3 session: %s", err.Error())
+	}
+
+	return S3StorageHandler{
+		targetName: targetName,
+		bucket:     c.Bucket,
+		prefix:     c.Prefix,
+		client:     s3.New(sess),
+		uploader:   s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (h S3StorageHandler) key(elem ...string) string {
+	return path.Join(append([]string{h.prefix}, elem...)...)
+}
+
+func (h S3StorageHandler) objectExists(key string) (bool, error) {
+	_, err := h.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("Could not check for object %s: %s", key, err.Error())
+	}
+	return true, nil
+}
+
+func (h S3StorageHandler) s3Upload(source, key string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	defer file.Close()
+
+	_, err = h.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	})
+	if err != nil {
+		return fmt.Errorf("Could not upload %s to %s: %s", source, h.bucket, err.Error())
+	}
+	return nil
+}
+
+func (h S3StorageHandler) s3Download(key, destination string) error {
+	object, err := h.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not download %s from %s: %s", key, h.bucket, err.Error())
+	}
+	defer object.Body.Close()
+
+	err = os.MkdirAll(filepath.Dir(destination), 0744)
+	if err != nil {
+		return fmt.Errorf("Cannot make directory: %s", err.Error())
+	}
+	file, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("Error writing file: %s", err.Error())
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, object.Body)
+	if err != nil {
+		return fmt.Errorf("Error writing file: %s", err.Error())
+	}
+	return nil
+}
+
+func (h S3StorageHandler) BackupExists() (bool, error) {
+	return h.objectExists(h.key("crashes", h.targetName, "backup.zip"))
+}
+
+func (h S3StorageHandler) GetTargetBackupLocation() string {
+	return filepath.Join(constants.LocalSyncDirectory, h.targetName, "backup.zip")
+}
+
+func (h S3StorageHandler) GetBackup() (string, error) {
+	outDir := h.GetTargetBackupLocation()
+	err := h.s3Download(h.key("crashes", h.targetName, "backup.zip"), outDir)
+	return outDir, err
+}
+
+// MakeBackup keeps the local archive if the upload fails, so that it isn't
+// lost to a transient error
+func (h S3StorageHandler) MakeBackup() error {
+	source := h.GetTargetBackupLocation()
+	err := h.s3Upload(source, h.key("crashes", h.targetName, "backup.zip"))
+	if err != nil {
+		return err
+	}
+	os.Remove(source)
+	return nil
+}
+
+func (h S3StorageHandler) SavePayload(source FuzzerPayload) (string, error) {
+	metadata, err := newPayloadMetadata(h.targetName, source)
+	payloadID := metadata.ID
+	if err != nil {
+		return payloadID, err
+	}
+	err = h.s3Upload(source.Location, h.key("crashes", h.targetName, payloadID))
+	if err != nil {
+		return payloadID, err
+	}
+	return payloadID, h.SaveMetadata(metadata)
+}
+
+func (h S3StorageHandler) s3PutJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not marshal %s: %s", path.Base(key), err.Error())
+	}
+	_, err = h.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not upload %s to %s: %s", key, h.bucket, err.Error())
+	}
+	return nil
+}
+
+func (h S3StorageHandler) s3GetJSON(key string, v interface{}) error {
+	object, err := h.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not download %s from %s: %s", key, h.bucket, err.Error())
+	}
+	defer object.Body.Close()
+	return json.NewDecoder(object.Body).Decode(v)
+}
+
+func (h S3StorageHandler) SaveOutput(source FuzzerPayloadOutput) error {
+	return h.s3PutJSON(h.key("crashes", h.targetName, outputName(source.Identifier)), source)
+}
+
+func (h S3StorageHandler) ListPayloads() ([]string, error) {
+	toReturn := []string{}
+	prefix := h.key("crashes", h.targetName) + "/"
+	// The delimiter leaves out the corpus
+	err := h.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(h.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+			if IsPayloadID(name) {
+				toReturn = append(toReturn, name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not list payloads in %s: %s", h.bucket, err.Error())
+	}
+	return toReturn, nil
+}
+
+func (h S3StorageHandler) PayloadExists(payloadID string) (bool, error) {
+	return h.objectExists(h.key("crashes", h.targetName, payloadID))
+}
+
+func (h S3StorageHandler) GetPayload(payloadID, destination string) error {
+	return h.s3Download(h.key("crashes", h.targetName, payloadID), destination)
+}
+
+func (h S3StorageHandler) GetOutput(payloadID string) (*FuzzerPayloadOutput, error) {
+	key := h.key("crashes", h.targetName, outputName(payloadID))
+	exists, err := h.objectExists(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+	toReturn := &FuzzerPayloadOutput{}
+	err = h.s3GetJSON(key, toReturn)
+	return toReturn, err
+}
+
+func (h S3StorageHandler) GetMetadata(payloadID string) (*PayloadMetadata, error) {
+	key := h.key("crashes", h.targetName, metadataName(payloadID))
+	exists, err := h.objectExists(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+	toReturn := &PayloadMetadata{}
+	err = h.s3GetJSON(key, toReturn)
+	return toReturn, err
+}
+
+func (h S3StorageHandler) SaveMetadata(metadata PayloadMetadata) error {
+	return h.s3PutJSON(h.key("crashes", h.targetName, metadataName(metadata.ID)), metadata)
+}
+
+func (h S3StorageHandler) GetBuckets() (map[string]*CrashBucket, error) {
+	toReturn := map[string]*CrashBucket{}
+	key := h.key("crashes", h.targetName, "buckets.json")
+	exists, err := h.objectExists(key)
+	if err != nil || !exists {
+		return toReturn, err
+	}
+	err = h.s3GetJSON(key, &toReturn)
+	return toReturn, err
+}
+
+func (h S3StorageHandler) SaveBuckets(buckets map[string]*CrashBucket) error {
+	return h.s3PutJSON(h.key("crashes", h.targetName, "buckets.json"), buckets)
+}
+
+func (h S3StorageHandler) GetTarget() (string, error) {
+	destination := filepath.Join(constants.LocalTargetDirectory, fmt.Sprintf(".%s_working.zip", h.targetName))
+	err := h.s3Download(h.key("targets", fmt.Sprintf("%s.zip", h.targetName)), destination)
+	return destination, err
+}
+
+func (h S3StorageHandler) SaveTarget(source string) (string, error) {
+	key := h.key("targets", fmt.Sprintf("%s.zip", h.targetName))
+	err := h.s3Upload(source, key)
+	return fmt.Sprintf("s3://%s/%s", h.bucket, key), err
+}
+
+func (h S3StorageHandler) DeleteTarget() error {
+	key := h.key("targets", fmt.Sprintf("%s.zip", h.targetName))
+	_, err := h.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not delete %s from %s: %s", key, h.bucket, err.Error())
+	}
+	return nil
+}
+
+func (h S3StorageHandler) ListCorpus() ([]string, error) {
+	toReturn := []string{}
+	prefix := h.key("crashes", h.targetName, "corpus") + "/"
+	err := h.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(h.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			toReturn = append(toReturn, strings.TrimPrefix(aws.StringValue(object.Key), prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not list corpus in %s: %s", h.bucket, err.Error())
+	}
+	return toReturn, nil
+}
+
+func (h S3StorageHandler) SaveCorpusEntry(hash, source string) error {
+	return h.s3Upload(source, h.key("crashes", h.targetName, "corpus", hash))
+}
+
+func (h S3StorageHandler) GetCorpusEntry(hash, destination string) error {
+	return h.s3Download(h.key("crashes", h.targetName, "corpus", hash), destination)
+}
@@ -0,0 +1,215 @@
+// +build unit
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is a minimal path-style S3 stand-in supporting PUT, GET, HEAD and
+// DELETE on single objects, and listing objects by prefix.
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.list(w, key, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+			return
+		}
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`))
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket, prefix, delimiter string) {
+	keys := []string{}
+	for k := range f.objects {
+		key := strings.TrimPrefix(k, bucket+"/")
+		if key == k || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// Keys past the delimiter would be common prefixes
+		if delimiter != "" && strings.Contains(strings.TrimPrefix(key, prefix), delimiter) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "<ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>", bucket, prefix, len(keys))
+	for _, k := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", k)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func newTestS3Handler(t *testing.T) (S3StorageHandler, *fakeS3, func()) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	tmp, err := ioutil.TempDir("", "maxfuzz-s3")
+	assert.Nil(t, err)
+	constants.LocalSyncDirectory = filepath.Join(tmp, "sync")
+	constants.LocalTargetDirectory = filepath.Join(tmp, "targets")
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	h, err := initS3Storage("target", config.S3{
+		Bucket:    "bucket",
+		Prefix:    "maxfuzz",
+		Endpoint:  server.URL,
+		PathStyle: true,
+	})
+	assert.Nil(t, err)
+
+	return h, fake, func() {
+		server.Close()
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestS3MissingBucket(t *testing.T) {
+	_, err := initS3Storage("target", config.S3{})
+	assert.NotNil(t, err)
+}
+
+func TestS3Backup(t *testing.T) {
+	h, fake, cleanup := newTestS3Handler(t)
+	defer cleanup()
+
+	exists, err := h.BackupExists()
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	content := []byte("backup")
+	os.MkdirAll(filepath.Dir(h.GetTargetBackupLocation()), 0755)
+	ioutil.WriteFile(h.GetTargetBackupLocation(), content, 0644)
+	assert.Nil(t, h.MakeBackup())
+	assert.Equal(t, content, fake.objects["bucket/maxfuzz/crashes/target/backup.zip"])
+	_, err = os.Stat(h.GetTargetBackupLocation())
+	assert.True(t, os.IsNotExist(err))
+
+	exists, err = h.BackupExists()
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	location, err := h.GetBackup()
+	assert.Nil(t, err)
+	result, err := ioutil.ReadFile(location)
+	assert.Nil(t, err)
+	assert.Equal(t, content, result)
+}
+
+func TestS3GetTarget(t *testing.T) {
+	h, fake, cleanup := newTestS3Handler(t)
+	defer cleanup()
+
+	_, err := h.GetTarget()
+	assert.NotNil(t, err)
+
+	content := []byte("target")
+	fake.objects["bucket/maxfuzz/targets/target.zip"] = content
+	location, err := h.GetTarget()
+	assert.Nil(t, err)
+	// Working copies can't collide with another target's bundle
+	assert.Equal(t, ".target_working.zip", filepath.Base(location))
+	result, err := ioutil.ReadFile(location)
+	assert.Nil(t, err)
+	assert.Equal(t, content, result)
+
+	assert.Nil(t, h.DeleteTarget())
+	assert.Empty(t, fake.objects)
+}
+
+func TestS3SavePayload(t *testing.T) {
+	h, fake, cleanup := newTestS3Handler(t)
+	defer cleanup()
+
+	content := []byte("crash")
+	source := filepath.Join(constants.LocalSyncDirectory, "id:000000")
+	os.MkdirAll(constants.LocalSyncDirectory, 0755)
+	ioutil.WriteFile(source, content, 0644)
+
+	payloadID, err := h.SavePayload(FuzzerPayload{Location: source})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(payloadID, "_id:000000"))
+	assert.Equal(t, content, fake.objects["bucket/maxfuzz/crashes/target/"+payloadID])
+}
+
+func TestS3SaveOutput(t *testing.T) {
+	h, fake, cleanup := newTestS3Handler(t)
+	defer cleanup()
+
+	err := h.SaveOutput(FuzzerPayloadOutput{Identifier: "1_crash", Output: []string{"panic"}, ExitCode: 2})
+	assert.Nil(t, err)
+	assert.Contains(t, string(fake.objects["bucket/maxfuzz/crashes/target/1_crash.output.json"]), `"ExitCode":2`)
+}
+
+func TestS3ListPayloads(t *testing.T) {
+	h, fake, cleanup := newTestS3Handler(t)
+	defer cleanup()
+
+	assert.Nil(t, h.SaveOutput(FuzzerPayloadOutput{Identifier: "1_crash", Category: "segv", Revision: "abc"}))
+	for _, key := range []string{"1_crash", "2_crash", "buckets.json", "backup.zip", "corpus/0a1b"} {
+		fake.objects["bucket/maxfuzz/crashes/target/"+key] = []byte("data")
+	}
+	fake.objects["bucket/maxfuzz/crashes/other/3_crash"] = []byte("data")
+
+	payloadIDs, err := h.ListPayloads()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1_crash", "2_crash"}, payloadIDs)
+	hashes, err := h.ListCorpus()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0a1b"}, hashes)
+
+	output, err := h.GetOutput("1_crash")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", output.Revision)
+	output, err = h.GetOutput("2_crash")
+	assert.Nil(t, err)
+	assert.Nil(t, output)
+
+	exists, err := h.PayloadExists("2_crash")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = h.PayloadExists("3_crash")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
@@ -1,31 +1,284 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/everestmz/maxfuzz/internal/helpers"
+	"github.com/everestmz/maxfuzz/internal/config"
 	"github.com/everestmz/maxfuzz/internal/logging"
 )
 
 type FuzzerPayload struct {
-	Category string
-	Location string
-	Revision string
+	Category   string
+	Location   string
+	Revision   string
+	TargetName string
+	Engine     string
+	Image      string // ID of the image the fuzzer ran in
+	Host       string // Docker host the fuzzer ran on
+	Instance   string // Fuzzer instance, for engines running several
+	Found      int64  // Unix time the payload was found, defaults to the file's modification time
+}
+
+// Reproduction states of a payload
+const (
+	ReproductionPending = "PENDING"
+	Reproduced          = "REPRODUCED"
+	ReproductionFailed  = "FAILED"
+)
+
+// PayloadMetadata is saved alongside each payload
+type PayloadMetadata struct {
+	ID           string `json:"id"`
+	Target       string `json:"target"`
+	TargetName   string `json:"target_name"`
+	Revision     string `json:"revision"`
+	Engine       string `json:"engine"`
+	Image        string `json:"image"`
+	Host         string `json:"host"`
+	Instance     string `json:"instance"`
+	Name         string `json:"name"` // Name the engine gave the payload
+	Category     string `json:"category"`
+	Time         int64  `json:"time"` // Unix time the payload was found
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	Reproduction string `json:"reproduction"`
+	Bucket       string `json:"bucket"` // Signature of the crash's bucket, once reproduced
+}
+
+// PayloadQuery selects payloads by metadata. Empty fields match any payload.
+type PayloadQuery struct {
+	Revision     string
+	Category     string
+	Engine       string
+	Reproduction string
+	Bucket       string
+	Since        int64 // Unix times
+	Until        int64
+	Offset       int // Number of matching payloads to skip
+	Limit        int // Maximum number of payloads to return, 0 for all
+}
+
+func (q PayloadQuery) matches(m *PayloadMetadata) bool {
+	return (q.Revision == "" || m.Revision == q.Revision) &&
+		(q.Category == "" || m.Category == q.Category) &&
+		(q.Engine == "" || m.Engine == q.Engine) &&
+		(q.Reproduction == "" || m.Reproduction == q.Reproduction) &&
+		(q.Bucket == "" || m.Bucket == q.Bucket) &&
+		m.Time >= q.Since &&
+		(q.Until == 0 || m.Time <= q.Until)
 }
 
 type FuzzerPayloadOutput struct {
-	Identifier string
-	Output     []string
+	Identifier string   // Payload ID returned by SavePayload
+	Output     []string // Lines written to stdout
+	Stderr     []string // Lines written to stderr
+	ExitCode   int
+	TimedOut   bool
+	Input      string // Quoted input, for engines that provide one
+	Category   string
+	Signal     int
+	Revision   string // Revision of the target the crash was found in
+	Bucket     string // Signature of the crash's bucket
+}
+
+// CrashBucket groups crashes that share a signature
+type CrashBucket struct {
+	Signature          string   `json:"signature"`
+	Frames             []string `json:"frames"`
+	Category           string   `json:"category"`
+	Count              int      `json:"count"`
+	FirstSeen          int64    `json:"first_seen"`
+	LastSeen           int64    `json:"last_seen"`
+	Representative     string   `json:"representative"` // Payload ID of the smallest input
+	RepresentativeSize int64    `json:"representative_size"`
+	Payloads           []string `json:"payloads"` // IDs of the payloads counted in the bucket
+}
+
+// outputName is the name outputs are stored under, next to their payload
+func outputName(payloadID string) string {
+	return fmt.Sprintf("%s.output.json", payloadID)
+}
+
+// metadataName is the name metadata is stored under, next to its payload
+func metadataName(payloadID string) string {
+	return fmt.Sprintf("%s.metadata.json", payloadID)
+}
+
+// Payload IDs are the unix time the payload was found at, then its file name.
+// Newer IDs put a prefix of the payload's SHA-256 before the file name, as
+// instances of an engine name their payloads alike.
+var payloadIDPattern = regexp.MustCompile(`^([0-9]+)_([^/]+)$`)
+
+// IsPayloadID reports whether name, the name of a file stored next to a
+// target's payloads, is a payload ID
+func IsPayloadID(name string) bool {
+	return payloadIDPattern.MatchString(name) &&
+		!strings.HasSuffix(name, outputName("")) &&
+		!strings.HasSuffix(name, metadataName(""))
+}
+
+// newPayloadMetadata describes a payload about to be saved, and names it
+func newPayloadMetadata(targetName string, source FuzzerPayload) (PayloadMetadata, error) {
+	name := filepath.Base(source.Location)
+	toReturn := PayloadMetadata{
+		Target:       targetName,
+		TargetName:   source.TargetName,
+		Revision:     source.Revision,
+		Engine:       source.Engine,
+		Image:        source.Image,
+		Host:         source.Host,
+		Instance:     source.Instance,
+		Name:         name,
+		Category:     source.Category,
+		Time:         source.Found,
+		Reproduction: ReproductionPending,
+	}
+	f, err := os.Open(source.Location)
+	if err != nil {
+		return toReturn, fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	defer f.Close()
+	if toReturn.Time == 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return toReturn, fmt.Errorf("Error reading file: %s", err.Error())
+		}
+		toReturn.Time = info.ModTime().Unix()
+	}
+	h := sha256.New()
+	toReturn.Size, err = io.Copy(h, f)
+	if err != nil {
+		return toReturn, fmt.Errorf("Error reading file: %s", err.Error())
+	}
+	toReturn.SHA256 = hex.EncodeToString(h.Sum(nil))
+	toReturn.ID = fmt.Sprintf("%d_%s_%s", toReturn.Time, toReturn.SHA256[:12], name)
+	return toReturn, nil
+}
+
+// PayloadTime returns the unix time a payload was found at
+func PayloadTime(payloadID string) int64 {
+	match := payloadIDPattern.FindStringSubmatch(payloadID)
+	if match == nil {
+		return 0
+	}
+	t, _ := strconv.ParseInt(match[1], 10, 64)
+	return t
 }
 
 type StorageHandler interface {
 	GetTarget() (string, error)
+	SaveTarget(source string) (string, error) // Returns where the bundle was stored
+	DeleteTarget() error                      // Removes the bundle stored by SaveTarget
 	BackupExists() (bool, error)
 	GetBackup() (string, error)
 	MakeBackup() error
 	SavePayload(FuzzerPayload) (string, error)
 	SaveOutput(FuzzerPayloadOutput) error
+	ListPayloads() ([]string, error)
+	PayloadExists(payloadID string) (bool, error)
+	GetPayload(payloadID, destination string) error
+	GetOutput(payloadID string) (*FuzzerPayloadOutput, error) // nil until the payload is reproduced
+	GetMetadata(payloadID string) (*PayloadMetadata, error)   // nil for payloads saved without any
+	SaveMetadata(PayloadMetadata) error
+	GetBuckets() (map[string]*CrashBucket, error)
+	SaveBuckets(map[string]*CrashBucket) error
 	GetTargetBackupLocation() string
+	// The shared corpus holds the inputs of every instance of a target, each
+	// stored under the SHA-256 of its content
+	ListCorpus() ([]string, error)
+	SaveCorpusEntry(hash, source string) error
+	GetCorpusEntry(hash, destination string) error
+}
+
+// Metadata returns a payload's metadata. Payloads saved before metadata was
+// recorded are described by their ID and output.
+func Metadata(h StorageHandler, targetName, payloadID string) (*PayloadMetadata, error) {
+	toReturn, err := h.GetMetadata(payloadID)
+	if err != nil || toReturn != nil {
+		return toReturn, err
+	}
+	toReturn = &PayloadMetadata{
+		ID:     payloadID,
+		Target: targetName,
+		Time:   PayloadTime(payloadID),
+	}
+	match := payloadIDPattern.FindStringSubmatch(payloadID)
+	if match != nil {
+		toReturn.Name = match[2]
+	}
+	output, err := h.GetOutput(payloadID)
+	if err != nil || output == nil {
+		return toReturn, err
+	}
+	toReturn.Revision = output.Revision
+	toReturn.Category = output.Category
+	toReturn.Bucket = output.Bucket
+	toReturn.Reproduction = Reproduced
+	return toReturn, nil
+}
+
+// QueryPayloads returns the metadata of a target's payloads matching q,
+// newest first. Payloads are ordered by their IDs, so that metadata is only
+// read until the requested page is filled.
+func QueryPayloads(h StorageHandler, targetName string, q PayloadQuery) ([]*PayloadMetadata, error) {
+	toReturn := []*PayloadMetadata{}
+	payloadIDs, err := h.ListPayloads()
+	if err != nil {
+		return toReturn, err
+	}
+	sort.Slice(payloadIDs, func(i, j int) bool {
+		ti, tj := PayloadTime(payloadIDs[i]), PayloadTime(payloadIDs[j])
+		if ti != tj {
+			return ti > tj
+		}
+		return payloadIDs[i] < payloadIDs[j]
+	})
+	skipped := 0
+	for _, id := range payloadIDs {
+		if q.Limit > 0 && len(toReturn) >= q.Limit {
+			break
+		}
+		// Saves reading metadata of payloads out of the time range
+		t := PayloadTime(id)
+		if t < q.Since || (q.Until > 0 && t > q.Until) {
+			continue
+		}
+		m, err := Metadata(h, targetName, id)
+		if err != nil {
+			return toReturn, err
+		}
+		if !q.matches(m) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		toReturn = append(toReturn, m)
+	}
+	return toReturn, nil
+}
+
+// SetReproduction records the outcome of reproducing a payload, and the
+// category and bucket it was triaged into, in its metadata
+func SetReproduction(h StorageHandler, targetName, payloadID, state, category, bucket string) error {
+	m, err := Metadata(h, targetName, payloadID)
+	if err != nil {
+		return err
+	}
+	m.Reproduction = state
+	m.Category = category
+	m.Bucket = bucket
+	return h.SaveMetadata(*m)
 }
 
 var soln StorageHandler
@@ -33,22 +286,32 @@ var soln StorageHandler
 // Init sets up connections to whatever storage mechanism is being used
 func Init(targetName string) (StorageHandler, error) {
 	var err error
-	opts := helpers.MaxfuzzOptions()
-	val, ok := opts["storageSolution"]
-	if !ok {
-		return nil, fmt.Errorf("No storageSolution specified in MAXFUZZ_OPTIONS")
-	}
+	storageConfig := config.Get().Storage
 
-	switch val {
+	switch storageConfig.Backend {
 	case "local":
 		soln, err = initLocalStorage(targetName)
 		if err != nil {
 			return nil, err
 		}
 
+		return soln, nil
+	case "s3":
+		soln, err = initS3Storage(targetName, storageConfig.S3)
+		if err != nil {
+			return nil, err
+		}
+
+		return soln, nil
+	case "coordinator":
+		soln, err = initCoordinatorStorage(targetName, config.Get().Cluster)
+		if err != nil {
+			return nil, err
+		}
+
 		return soln, nil
 	default:
-		return nil, fmt.Errorf("Invalid storageSolution in MAXFUZZ_OPTIONS")
+		return nil, fmt.Errorf("Invalid storage backend %s", storageConfig.Backend)
 	}
 }
 
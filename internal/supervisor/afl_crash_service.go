@@ -4,28 +4,33 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
 	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+	"github.com/everestmz/maxfuzz/internal/types"
 
 	"github.com/howeyc/fsnotify"
 )
 
 type AFLCrashService struct {
-	logger   logging.Logger
-	stop     chan bool
-	target   string
-	revision string
+	crashSource
+	logger    logging.Logger
+	stop      chan bool
+	instances int
+	queue     reproduction.Queue
 }
 
-func NewAFLCrashService(target, revision string, l logging.Logger) AFLCrashService {
+func NewAFLCrashService(target *types.Target, instances int, l logging.Logger, queue reproduction.Queue) AFLCrashService {
 	return AFLCrashService{
-		logger:   l,
-		stop:     make(chan bool),
-		target:   target,
-		revision: revision,
+		crashSource: newCrashSource(target),
+		logger:      l,
+		stop:        make(chan bool),
+		instances:   instances,
+		queue:       queue,
 	}
 }
 
@@ -42,52 +47,71 @@ func (s AFLCrashService) Serve() {
 		return
 	}
 
-	watchDirectories := []string{
-		filepath.Join(constants.LocalSyncDirectory, s.target, "crashes"),
-		filepath.Join(constants.LocalSyncDirectory, s.target, "hangs"),
+	crashDirectories := []string{}
+	for _, d := range aflInstanceDirectories(s.target, s.instances) {
+		crashDirectories = append(crashDirectories,
+			filepath.Join(d, "crashes"),
+			filepath.Join(d, "hangs"),
+		)
 	}
 
 	watcher, err := fsnotify.NewWatcher()
 	panicOnError(err)
+	defer watcher.Close()
 
 	// Wait for AFL crash directories to exist
 	s.logger.Info("AFLCrashService waiting for crash directories")
 	exists := false
 	for !exists {
 		exists = true
-		for _, d := range watchDirectories {
+		for _, d := range crashDirectories {
 			if !helpers.Exists(d) {
 				exists = false
 			}
 		}
 	}
 
-	for _, d := range watchDirectories {
-		err = watcher.Watch(d)
-		panicOnError(err)
-	}
+	watched, err := watchDirectories(watcher, crashDirectories)
+	panicOnError(err)
 
 	s.logger.Info("AFLCrashService watching crash directories")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case ev := <-watcher.Event:
 			if ev.IsCreate() && !strings.Contains(ev.Name, "README.txt") {
 				crashID := filepath.Base(ev.Name)
 				s.logger.Info(fmt.Sprintf("Bug found: %s", crashID))
-				// TODO: actually parse the category properly
-				payload := storage.FuzzerPayload{
-					Location: ev.Name,
-					Category: "CRASH",
-					Revision: s.revision,
-				}
-				// TODO: Reproduce the crash and use the unique ID from save to store it
-				_, err = storageHandler.SavePayload(payload)
+				// Refined by the reproduction service once the crash is
+				// reproduced
+				classification := triage.Classify([]string{}, 0, false, ev.Name)
+				// Instances write to <instance>/crashes
+				instance := filepath.Base(filepath.Dir(filepath.Dir(ev.Name)))
+				payload := s.payload(ev.Name, instance, classification.Category)
+				payloadID, err := storageHandler.SavePayload(payload)
 				if err != nil {
 					s.logger.Error(fmt.Sprintf("AFLCrashService Could not save bug payload: %s", err.Error()))
+					continue
+				}
+				err = s.queue.Produce(reproduction.Crash{
+					Filename:  ev.Name,
+					Kind:      payload.Category,
+					Target:    s.target,
+					PayloadID: payloadID,
+					Revision:  s.revision,
+				})
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("AFLCrashService Could not queue bug for reproduction: %s", err.Error()))
 				}
 			}
 		case err := <-watcher.Error:
 			s.logger.Error(fmt.Sprintf("AFLCrashService: %s", err.Error()))
+		case <-ticker.C:
+			if directoriesReplaced(watched) {
+				s.logger.Info("AFLCrashService crash directories replaced, restarting")
+				return
+			}
 		case <-s.stop:
 			return
 		}
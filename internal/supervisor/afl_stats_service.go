@@ -9,24 +9,28 @@ import (
 	"strings"
 	"time"
 
-	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/triage"
 )
 
 type AFLStatsService struct {
-	logger logging.Logger
-	stop   chan bool
-	stats  chan *TargetStats
-	target string
+	logger    logging.Logger
+	stop      chan bool
+	stats     chan *TargetStats
+	target    string
+	instances int
+	buckets   *triage.Buckets
 }
 
-func NewAFLStatsService(target string, l logging.Logger, statsChan chan *TargetStats) AFLStatsService {
+func NewAFLStatsService(target string, instances int, l logging.Logger, statsChan chan *TargetStats, buckets *triage.Buckets) AFLStatsService {
 	return AFLStatsService{
-		logger: l,
-		stop:   make(chan bool),
-		target: target,
-		stats:  statsChan,
+		logger:    l,
+		stop:      make(chan bool),
+		target:    target,
+		stats:     statsChan,
+		instances: instances,
+		buckets:   buckets,
 	}
 }
 
@@ -35,12 +39,152 @@ func (s AFLStatsService) Stop() {
 	s.stop <- true
 }
 
+// parseAFLPercent parses values like "12.34%"
+func parseAFLPercent(v string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+}
+
+// readAFLStats parses the fuzzer_stats file of a single AFL instance
+func readAFLStats(statsFile string, now time.Time) (TargetStats, error) {
+	toReturn := TargetStats{}
+	statsMap := map[string]string{}
+	file, err := os.Open(statsFile)
+	if err != nil {
+		return toReturn, err
+	}
+	defer file.Close()
+
+	// This adds lines like "key : val" to statsMap[key] = val
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		spl := strings.SplitN(scanner.Text(), ":", 2)
+		if len(spl) != 2 {
+			continue
+		}
+		k := strings.TrimSpace(spl[0])
+		v := strings.TrimSpace(spl[1])
+		statsMap[k] = v
+	}
+
+	execsPerSecond, err := strconv.ParseFloat(
+		statsMap["execs_per_sec"],
+		64,
+	)
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse execs_per_sec: %s", err.Error())
+	}
+	toReturn.TestsPerSecond = execsPerSecond
+	uniqueCrashes, err := strconv.Atoi(statsMap["unique_crashes"])
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse unique_crashes: %s", err.Error())
+	}
+	uniqueHangs, err := strconv.Atoi(statsMap["unique_hangs"])
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse unique_hangs: %s", err.Error())
+	}
+	toReturn.Crashes = uniqueCrashes + uniqueHangs
+	totalExecs, err := strconv.ParseInt(statsMap["execs_done"], 10, 64)
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse execs_done: %s", err.Error())
+	}
+	toReturn.TotalExecs = totalExecs
+	corpusSize, err := strconv.Atoi(statsMap["paths_total"])
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse paths_total: %s", err.Error())
+	}
+	toReturn.CorpusSize = corpusSize
+	coverage, err := parseAFLPercent(statsMap["bitmap_cvg"])
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse bitmap_cvg: %s", err.Error())
+	}
+	toReturn.Coverage = coverage
+	cycles, err := strconv.Atoi(statsMap["cycles_done"])
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse cycles_done: %s", err.Error())
+	}
+	toReturn.Cycles = cycles
+	// Only reported by AFL 2.52b onwards
+	if v, ok := statsMap["stability"]; ok {
+		stability, err := parseAFLPercent(v)
+		if err != nil {
+			return toReturn, fmt.Errorf("Could not parse stability: %s", err.Error())
+		}
+		toReturn.Stability = stability
+	}
+	startTime, err := strconv.ParseInt(statsMap["start_time"], 10, 64)
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse start_time: %s", err.Error())
+	}
+	toReturn.Uptime = now.Unix() - startTime
+	lastPath, err := strconv.ParseInt(statsMap["last_path"], 10, 64)
+	if err != nil {
+		return toReturn, fmt.Errorf("Could not parse last_path: %s", err.Error())
+	}
+	// last_path is 0 until the first new path is found
+	toReturn.SinceLastPath = toReturn.Uptime
+	if lastPath > 0 {
+		toReturn.SinceLastPath = now.Unix() - lastPath
+	}
+	return toReturn, nil
+}
+
+// aggregateAFLStats combines the stats of every instance that has written a
+// fuzzer_stats file. Instances share their corpus through the sync directory,
+// so the largest corpus and coverage are those of the whole cluster, and the
+// cluster finds a new path whenever any instance does. Stability is averaged.
+func aggregateAFLStats(statsFiles []string, now time.Time) (TargetStats, error) {
+	toReturn := TargetStats{}
+	instances := 0
+	for _, statsFile := range statsFiles {
+		if !helpers.Exists(statsFile) {
+			continue
+		}
+		instanceStats, err := readAFLStats(statsFile, now)
+		if err != nil {
+			return toReturn, err
+		}
+		toReturn.TestsPerSecond += instanceStats.TestsPerSecond
+		toReturn.Crashes += instanceStats.Crashes
+		toReturn.TotalExecs += instanceStats.TotalExecs
+		toReturn.Stability += instanceStats.Stability
+		if instanceStats.CorpusSize > toReturn.CorpusSize {
+			toReturn.CorpusSize = instanceStats.CorpusSize
+		}
+		if instanceStats.Coverage > toReturn.Coverage {
+			toReturn.Coverage = instanceStats.Coverage
+		}
+		if instanceStats.Cycles > toReturn.Cycles {
+			toReturn.Cycles = instanceStats.Cycles
+		}
+		if instanceStats.Uptime > toReturn.Uptime {
+			toReturn.Uptime = instanceStats.Uptime
+		}
+		if instances == 0 || instanceStats.SinceLastPath < toReturn.SinceLastPath {
+			toReturn.SinceLastPath = instanceStats.SinceLastPath
+		}
+		instances++
+	}
+	if instances > 0 {
+		toReturn.Stability /= float64(instances)
+	}
+	return toReturn, nil
+}
+
 func (s AFLStatsService) Serve() {
 	s.logger.Info("AFLStatsService starting")
-	statsFile := filepath.Join(constants.LocalSyncDirectory, s.target, "fuzzer_stats")
+	statsFiles := []string{}
+	for _, d := range aflInstanceDirectories(s.target, s.instances) {
+		statsFiles = append(statsFiles, filepath.Join(d, "fuzzer_stats"))
+	}
 
+	// Instances that haven't written their stats yet are skipped by
+	// aggregateAFLStats
 	s.logger.Info("AFLStatsService waiting for fuzzer to initialize")
-	for !helpers.Exists(statsFile) {
+	initialized := false
+	for !initialized {
+		for _, statsFile := range statsFiles {
+			initialized = initialized || helpers.Exists(statsFile)
+		}
 	}
 
 	s.logger.Info("AFLStatsService watching statistics")
@@ -51,48 +195,15 @@ func (s AFLStatsService) Serve() {
 			ticker.Stop()
 			return
 		case <-ticker.C:
-			statsMap := map[string]string{}
-			file, err := os.Open(statsFile)
+			newStats, err := aggregateAFLStats(statsFiles, time.Now())
 			if err != nil {
 				s.logger.Error(fmt.Sprintf("AFLStatsService %s", err.Error()))
 				return
 			}
-
-			// This adds lines like "key : val" to statsMap[key] = val
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				spl := strings.Split(scanner.Text(), ":")
-				k := strings.TrimSpace(spl[0])
-				v := strings.TrimSpace(spl[1])
-				statsMap[k] = v
-			}
-
-			newStats := TargetStats{}
 			newStats.ID = s.target
-			execsPerSecond, err := strconv.ParseFloat(
-				statsMap["execs_per_sec"],
-				64,
-			)
-			if err != nil {
-				s.logger.Error(fmt.Sprintf("AFLStatsService could not parse execs_per_sec: %s", err.Error()))
-				return
-			}
-			newStats.TestsPerSecond = execsPerSecond
-			uniqueCrashes, err := strconv.Atoi(statsMap["unique_crashes"])
-			if err != nil {
-				s.logger.Error(fmt.Sprintf("AFLStatsService could not parse unique_crashes: %s", err.Error()))
-				return
-			}
-
-			uniqueHangs, err := strconv.Atoi(statsMap["unique_hangs"])
-			if err != nil {
-				s.logger.Error(fmt.Sprintf("AFLStatsService could not parse unique_hangs: %s", err.Error()))
-				return
-			}
-			newStats.BugsFound = uniqueCrashes + uniqueHangs
+			newStats.BugsFound = s.buckets.Count()
 
 			s.stats <- &newStats
-			file.Close()
 		}
 	}
 }
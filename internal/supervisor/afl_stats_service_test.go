@@ -0,0 +1,98 @@
+// +build unit
+
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/helpers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeAFLStats(t *testing.T, path string, execsPerSec float64, execs, crashes, hangs, paths int, coverage, stability float64, lastPath int64) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	content := fmt.Sprintf(
+		"start_time        : 1537000000\nlast_update       : 1537000600\ncycles_done       : 3\nexecs_done        : %d\nexecs_per_sec     : %.2f\npaths_total       : %d\nlast_path         : %d\nunique_crashes    : %d\nunique_hangs      : %d\nbitmap_cvg        : %.2f%%\nstability         : %.2f%%\ncommand_line      : afl-fuzz -M main -i- -o /root/fuzz_out -- /root/fuzzer/target\n",
+		execs, execsPerSec, paths, lastPath, crashes, hangs, coverage, stability,
+	)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestAFLInstanceDirectories(t *testing.T) {
+	constants.LocalSyncDirectory = "/sync"
+	assert.Equal(t, []string{"/sync/target/main"}, aflInstanceDirectories("target", 1))
+	assert.Equal(t,
+		[]string{"/sync/target/main", "/sync/target/secondary01", "/sync/target/secondary02"},
+		aflInstanceDirectories("target", 3),
+	)
+}
+
+func TestMigrateAFLLayout(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-afl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	constants.LocalSyncDirectory = tmp
+
+	writeAFLStats(t, filepath.Join(tmp, "target", "fuzzer_stats"), 1, 1, 0, 0, 1, 1, 100, 0)
+	assert.Nil(t, os.MkdirAll(filepath.Join(tmp, "target", "queue"), 0755))
+	assert.Nil(t, migrateAFLLayout("target"))
+	assert.True(t, helpers.Exists(filepath.Join(tmp, "target", "main", "fuzzer_stats")))
+	assert.True(t, helpers.Exists(filepath.Join(tmp, "target", "main", "queue")))
+	assert.False(t, helpers.Exists(filepath.Join(tmp, "target", "queue")))
+
+	// Already migrated
+	assert.Nil(t, migrateAFLLayout("target"))
+	assert.True(t, helpers.Exists(filepath.Join(tmp, "target", "main", "queue")))
+}
+
+func TestSetupAFLCmds(t *testing.T) {
+	env := map[string]string{
+		"AFL_FUZZ":         "afl-fuzz",
+		"AFL_MEMORY_LIMIT": "none",
+		"AFL_BINARY":       "/root/fuzzer/target",
+	}
+	commands, err := setupAFLCmds(env, "-i- -o /root/fuzz_out", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"afl-fuzz", "-M", "main", "-i-", "-o", "/root/fuzz_out", "-m", "none", "--", "/root/fuzzer/target"},
+	}, commands)
+
+	commands, err = setupAFLCmds(env, "-i /root/fuzz_in -o /root/fuzz_out", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"afl-fuzz", "-M", "main", "-i", "/root/fuzz_in", "-o", "/root/fuzz_out", "-m", "none", "--", "/root/fuzzer/target"},
+		{"afl-fuzz", "-S", "secondary01", "-i", "/root/fuzz_in", "-o", "/root/fuzz_out", "-m", "none", "--", "/root/fuzzer/target"},
+	}, commands)
+}
+
+func TestAggregateAFLStats(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-afl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+
+	main := filepath.Join(tmp, "main", "fuzzer_stats")
+	secondary := filepath.Join(tmp, "secondary01", "fuzzer_stats")
+	missing := filepath.Join(tmp, "secondary02", "fuzzer_stats")
+	writeAFLStats(t, main, 100.5, 1000, 2, 1, 40, 1.5, 100, 1537000100)
+	writeAFLStats(t, secondary, 200, 3000, 1, 0, 42, 1.25, 99, 0)
+
+	stats, err := aggregateAFLStats([]string{main, secondary, missing}, time.Unix(1537000600, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 300.5, stats.TestsPerSecond)
+	assert.Equal(t, int64(4000), stats.TotalExecs)
+	assert.Equal(t, 4, stats.Crashes)
+	assert.Equal(t, 42, stats.CorpusSize)
+	assert.Equal(t, 1.5, stats.Coverage)
+	assert.Equal(t, 3, stats.Cycles)
+	assert.Equal(t, 99.5, stats.Stability)
+	assert.Equal(t, int64(600), stats.Uptime)
+	// The secondary hasn't found a path, so the main's last path counts
+	assert.Equal(t, int64(500), stats.SinceLastPath)
+}
@@ -3,10 +3,13 @@ package supervisor
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/everestmz/maxfuzz/internal/config"
 	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
 	"github.com/everestmz/maxfuzz/internal/storage"
 
 	"github.com/mholt/archiver"
@@ -39,30 +42,75 @@ func (s BackupService) Serve() {
 		return
 	}
 
-	ticker := time.NewTicker(time.Duration(10) * time.Minute)
+	ticker := time.NewTicker(config.Get().BackupInterval)
 	for {
 		select {
 		case <-s.stop:
 			ticker.Stop()
 			return
 		case <-ticker.C:
-			outFilePath := storageHandler.GetTargetBackupLocation()
-			files, err := filepath.Glob(filepath.Join(constants.LocalSyncDirectory, s.target, "*"))
-			if err != nil {
-				s.logger.Error(fmt.Sprintf("BackupService %s", err.Error()))
-				return
+			// Frozen fuzzers were backed up when they were paused
+			if TargetLifecycle(s.target).State == Paused {
+				continue
 			}
-			err = archiver.Zip.Make(outFilePath, files)
-			if err != nil {
-				s.logger.Error(fmt.Sprintf("BackupService could not compress output for backup:\n%s", err.Error()))
-				return
+			// Backups are only reported while fuzzing, so as not to hide
+			// builds and failures
+			reported := swapLifecycleState(s.target, Fuzzing, BackingUp)
+			err = backupTarget(s.target, storageHandler)
+			if reported {
+				swapLifecycleState(s.target, BackingUp, Fuzzing)
 			}
-			err = storageHandler.MakeBackup()
+			metrics.BackupFinished(s.target, err)
 			if err != nil {
-				s.logger.Error(fmt.Sprintf("BackupService could not make backup:\n%s", err.Error()))
+				s.logger.Error(fmt.Sprintf("BackupService %s", err.Error()))
 				return
 			}
 			s.logger.Info("BackupService backup successful")
 		}
 	}
 }
+
+// Targets are backed up by their BackupService, their CorpusMinimizeService
+// and when they're paused, which share the archive, so one at a time
+var backupLocks = map[string]*sync.Mutex{}
+var backupLocksLock sync.Mutex
+
+func backupLock(target string) *sync.Mutex {
+	backupLocksLock.Lock()
+	defer backupLocksLock.Unlock()
+	l, ok := backupLocks[target]
+	if !ok {
+		l = &sync.Mutex{}
+		backupLocks[target] = l
+	}
+	return l
+}
+
+// backupTarget compresses the sync directory of a target and saves it through
+// its StorageHandler. Fuzzers restarted later resume from the backup.
+func backupTarget(target string, h storage.StorageHandler) error {
+	l := backupLock(target)
+	l.Lock()
+	defer l.Unlock()
+	outFilePath := h.GetTargetBackupLocation()
+	matches, err := filepath.Glob(filepath.Join(constants.LocalSyncDirectory, target, "*"))
+	if err != nil {
+		return err
+	}
+	// Archives kept after failed uploads are replaced, not archived
+	files := []string{}
+	for _, f := range matches {
+		if f != outFilePath {
+			files = append(files, f)
+		}
+	}
+	err = archiver.Zip.Make(outFilePath, files)
+	if err != nil {
+		return fmt.Errorf("Could not compress output for backup:\n%s", err.Error())
+	}
+	err = h.MakeBackup()
+	if err != nil {
+		return fmt.Errorf("Could not make backup:\n%s", err.Error())
+	}
+	return nil
+}
@@ -6,11 +6,14 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/everestmz/maxfuzz/internal/config"
 	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/docker"
-	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
 	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
 	"github.com/everestmz/maxfuzz/internal/types"
 
 	"github.com/go-cmd/cmd"
@@ -24,6 +27,8 @@ type CFuzzerService struct {
 	targetName string
 	stop       chan bool
 	baseImage  string
+	instances  int
+	resources  types.Resources
 }
 
 var aflCmdOptions = cmd.Options{
@@ -34,15 +39,26 @@ var aflCmdOptions = cmd.Options{
 func NewCFuzzer(target *types.Target, stats chan *TargetStats) *suture.Supervisor {
 	log := logging.NewTargetLogger(target.Name)
 	ret := New(log, target.Name)
+	instances := target.Instances
+	if instances < 1 {
+		instances = 1
+	}
+	queue := reproduction.NewQueue(target.UniqueID)
+	buckets := triage.NewBuckets(target.UniqueID)
 	ret.Add(NewBackupService(target.UniqueID, log))
-	ret.Add(NewAFLStatsService(target.UniqueID, log, stats))
-	ret.Add(NewAFLCrashService(target.UniqueID, target.Revision, log))
+	ret.Add(NewAFLStatsService(target.UniqueID, instances, log, stats, buckets))
+	ret.Add(NewAFLCrashService(target, instances, log, queue))
+	ret.Add(NewReproductionService(target.UniqueID, log, queue, buckets))
+	ret.Add(NewCorpusMinimizeService(target.UniqueID, log, aflMinimizations(instances)))
+	ret.Add(NewCorpusSyncService(target.UniqueID, log, aflCorpusLayout(instances)))
 	ret.Add(CFuzzerService{
 		log,
 		target.UniqueID,
 		target.Name,
 		make(chan bool),
 		"fuzzbox_c",
+		instances,
+		target.Resources,
 	})
 	return ret
 }
@@ -55,17 +71,31 @@ func (s CFuzzerService) Stop() {
 
 func (s CFuzzerService) Serve() {
 	s.logger.Info(fmt.Sprintf("CFuzzerService starting"))
+	if waitIfFailed(s.targetID, s.stop, s.logger) || waitIfPaused(s.targetID, s.stop, s.logger) {
+		return
+	}
+	metrics.FuzzerStarted(s.targetID)
+	defer metrics.SetContainerState(s.targetID, metrics.Stopped)
 	storageHandler, err := storage.Init(s.targetID)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not initialize storageHandler: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
 	// Pre-run sync and download steps
+	setLifecycleState(s.targetID, Fetching)
 	s.logger.Info(fmt.Sprintf("CFuzzerService setting up target"))
-	aflIoOptions, err := initialFuzzerSetup(s.targetID, s.logger, storageHandler)
+	aflIoOptions, err := initialFuzzerSetup(s.targetID, s.logger, storageHandler, false)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not initialize fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+	err = migrateAFLLayout(s.targetID)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("CFuzzerService could not migrate the sync directory: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
@@ -73,13 +103,13 @@ func (s CFuzzerService) Serve() {
 	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, s.targetID, "environment"))
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not parse the environment: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 	environment := gotenv.Parse(environmentFile)
 
 	// Run the build steps
-	opts := helpers.MaxfuzzOptions()
-	suppress := opts["suppressFuzzerOutput"] == "1"
+	suppress := config.Get().SuppressFuzzerOutput
 	stdout := stdoutWriter{
 		suppressOutput: suppress,
 		target:         s.targetName,
@@ -89,32 +119,49 @@ func (s CFuzzerService) Serve() {
 		target:         s.targetName,
 	}
 	s.logger.Info(fmt.Sprintf("CFuzzerService running build steps"))
-	config, err := docker.CreateFuzzer(s.targetID, s.baseImage, s.stop, map[string]string{}, stdout, stderr)
+	setLifecycleState(s.targetID, Building)
+	metrics.SetContainerState(s.targetID, metrics.Building)
+	buildStart := time.Now()
+	config, err := docker.CreateFuzzer(s.targetID, s.baseImage, s.stop, map[string]string{}, s.resources, stdout, stderr)
 	if err != nil {
+		if err == docker.ErrOOMKilled {
+			recordOOMKill(s.targetID)
+		}
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not build the fuzzer: %s", err.Error()))
+		if !stopped(err) {
+			lifecycleFailed(s.targetID, err)
+		}
 		return
 	}
 
+	metrics.BuildFinished(s.targetID, time.Since(buildStart))
+
 	// Finally, run the fuzzer
 	s.logger.Info(fmt.Sprintf("CFuzzerService running fuzzer"))
-	command, err := setupAFLCmd(environment, aflIoOptions)
+	commands, err := setupAFLCmds(environment, aflIoOptions, s.instances)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not set up the fuzz command: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
-	fuzzCluster, err := config.Deploy(command, stdout, stderr)
+	fuzzCluster, err := config.Deploy(commands, stdout, stderr)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
 	clusterState, err := fuzzCluster.State()
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("CFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
+	metrics.SetContainerState(s.targetID, metrics.Running)
+	setLifecycleState(s.targetID, Fuzzing)
 
+	frozen := false
 	ticker := time.NewTicker(time.Second)
 	for {
 		select {
@@ -130,15 +177,27 @@ func (s CFuzzerService) Serve() {
 			clusterState, err = fuzzCluster.State()
 			if err != nil {
 				s.logger.Error(fmt.Sprintf("CFuzzerService could not start the fuzzer: %s", err.Error()))
+				lifecycleFailed(s.targetID, err)
 				return
 			}
 			if !clusterState.Running() {
-				s.logger.Error(
-					fmt.Sprintf(
-						"CFuzzerService fuzz cluster stopped unexpectedly\nErrors: %s\nExit code: %v",
-						err.Error(), clusterState.ExitCode()))
+				if stopExpected(s.targetID, s.stop) {
+					s.logger.Info("CFuzzerService fuzzers were stopped, restarting")
+				} else if clusterState.OOMKilled() {
+					recordOOMKill(s.targetID)
+					s.logger.Error("CFuzzerService fuzz cluster ran out of memory")
+					lifecycleFailed(s.targetID, fmt.Errorf("Fuzzer ran out of memory"))
+				} else {
+					s.logger.Error(
+						fmt.Sprintf(
+							"CFuzzerService fuzz cluster stopped unexpectedly\nExit code: %v",
+							clusterState.ExitCode()))
+					lifecycleFailed(s.targetID, fmt.Errorf("Fuzzer exited with code %v", clusterState.ExitCode()))
+				}
+				fuzzCluster.Kill()
 				return
 			}
+			frozen = followPause(s.targetID, frozen, storageHandler, s.logger)
 		}
 	}
 }
@@ -0,0 +1,294 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/storage"
+
+	"github.com/subosito/gotenv"
+)
+
+var minimizeTimeout = time.Hour
+
+type MinimizationStats struct {
+	Time   int64 `json:"time"`   // When the last minimization finished
+	Before int   `json:"before"` // Corpus size before minimization
+	After  int   `json:"after"`  // Corpus size after minimization
+}
+
+// Minimization requests and results of running targets, by target ID
+var minimizeRequests = map[string]chan bool{}
+var minimizations = map[string]*MinimizationStats{}
+var minimizeLock sync.Mutex
+
+// RequestMinimization asks the CorpusMinimizeService of a running target to
+// minimize its corpus
+func RequestMinimization(target string) error {
+	if TargetPaused(target) {
+		return fmt.Errorf("Target %s is paused", target)
+	}
+	minimizeLock.Lock()
+	requests, ok := minimizeRequests[target]
+	minimizeLock.Unlock()
+	if !ok {
+		return fmt.Errorf("Target %s is not running, or its engine doesn't support minimization", target)
+	}
+	select {
+	case requests <- true:
+		return nil
+	default:
+		return fmt.Errorf("Target %s already has a minimization pending", target)
+	}
+}
+
+// LastMinimization returns the result of the last minimization of a target,
+// or nil if it hasn't been minimized
+func LastMinimization(target string) *MinimizationStats {
+	minimizeLock.Lock()
+	defer minimizeLock.Unlock()
+	return minimizations[target]
+}
+
+// corpusMinimization is a minimization command, run in the target's image,
+// that writes the minimized version of a corpus to another directory. Both are
+// relative to the sync directory.
+type corpusMinimization struct {
+	corpus    string
+	minimized string
+	command   []string
+}
+
+func aflMinimizations(instances int) func(map[string]string) ([]corpusMinimization, error) {
+	return func(env map[string]string) ([]corpusMinimization, error) {
+		aflBinary, ok := env["AFL_FUZZ"]
+		if !ok {
+			return nil, fmt.Errorf("AFL_FUZZ not populated in environment")
+		}
+		aflMemoryLimit, ok := env["AFL_MEMORY_LIMIT"]
+		if !ok {
+			return nil, fmt.Errorf("AFL_MEMORY_LIMIT not populated in environment")
+		}
+		aflBinaryLocation, ok := env["AFL_BINARY"]
+		if !ok {
+			return nil, fmt.Errorf("AFL_BINARY not populated in environment")
+		}
+		cmin := filepath.Join(filepath.Dir(aflBinary), "afl-cmin")
+
+		toReturn := []corpusMinimization{}
+		for i := 0; i < instances; i++ {
+			d := aflInstanceName(i)
+			corpus := filepath.Join(d, "queue")
+			minimized := filepath.Join(d, "queue_min")
+			toReturn = append(toReturn, corpusMinimization{
+				corpus:    corpus,
+				minimized: minimized,
+				command: []string{cmin,
+					"-i", filepath.Join(constants.FuzzerOutputDirectory, corpus),
+					"-o", filepath.Join(constants.FuzzerOutputDirectory, minimized),
+					"-m", aflMemoryLimit, "--", aflBinaryLocation},
+			})
+		}
+		return toReturn, nil
+	}
+}
+
+func libFuzzerMinimizations(env map[string]string) ([]corpusMinimization, error) {
+	binary, ok := env["LIBFUZZER_BINARY"]
+	if !ok {
+		return nil, fmt.Errorf("LIBFUZZER_BINARY not populated in environment")
+	}
+	minimized := libFuzzerCorpus + "_min"
+	return []corpusMinimization{{
+		corpus:    libFuzzerCorpus,
+		minimized: minimized,
+		command: []string{"/bin/sh", "-c", fmt.Sprintf("mkdir -p %s && %s -merge=1 %s %s",
+			filepath.Join(constants.FuzzerOutputDirectory, minimized),
+			binary,
+			filepath.Join(constants.FuzzerOutputDirectory, minimized),
+			filepath.Join(constants.FuzzerOutputDirectory, libFuzzerCorpus),
+		)},
+	}}, nil
+}
+
+// corpusSize counts the inputs in a corpus directory
+func corpusSize(directory string) int {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return 0
+	}
+	toReturn := 0
+	for _, f := range files {
+		if !f.IsDir() {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+type CorpusMinimizeService struct {
+	logger        logging.Logger
+	stop          chan bool
+	target        string
+	minimizations func(map[string]string) ([]corpusMinimization, error)
+}
+
+func NewCorpusMinimizeService(target string, l logging.Logger, minimizations func(map[string]string) ([]corpusMinimization, error)) CorpusMinimizeService {
+	return CorpusMinimizeService{
+		logger:        l,
+		stop:          make(chan bool),
+		target:        target,
+		minimizations: minimizations,
+	}
+}
+
+func (s CorpusMinimizeService) Stop() {
+	s.logger.Info("CorpusMinimizeService stopping")
+	s.stop <- true
+}
+
+func (s CorpusMinimizeService) Serve() {
+	s.logger.Info("CorpusMinimizeService starting")
+	storageHandler, err := storage.Init(s.target)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Could not initialize storage client:\n%s", err.Error()))
+		return
+	}
+
+	// An interval of 0 only minimizes on request
+	interval := config.Get().MinimizeInterval
+	var schedule <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		schedule = ticker.C
+	}
+
+	requests := make(chan bool, 1)
+	minimizeLock.Lock()
+	minimizeRequests[s.target] = requests
+	minimizeLock.Unlock()
+	defer func() {
+		minimizeLock.Lock()
+		delete(minimizeRequests, s.target)
+		minimizeLock.Unlock()
+	}()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-schedule:
+		case <-requests:
+		}
+		// Minimization thaws the fuzzers, so it is skipped while the target
+		// is paused
+		if TargetPaused(s.target) {
+			s.logger.Info("CorpusMinimizeService skipping minimization of paused target")
+			continue
+		}
+		s.logger.Info("CorpusMinimizeService minimizing corpus")
+		result, err := s.minimize(storageHandler)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("CorpusMinimizeService could not minimize corpus: %s", err.Error()))
+			continue
+		}
+		minimizeLock.Lock()
+		minimizations[s.target] = result
+		minimizeLock.Unlock()
+		s.logger.Info(fmt.Sprintf("CorpusMinimizeService minimized corpus from %d to %d inputs", result.Before, result.After))
+	}
+}
+
+// replaceCorpus swaps a corpus for its minimized version, leaving the corpus
+// as it was if it can't
+func replaceCorpus(corpus, minimized string) error {
+	old := corpus + "_old"
+	os.RemoveAll(old)
+	err := os.Rename(corpus, old)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(minimized, corpus)
+	if err != nil {
+		os.Rename(old, corpus)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// minimize pauses the fuzzers while their corpora are minimized, then backs
+// up the minimized corpora and kills the fuzzers, which resume from the backup
+// when they are restarted. Crash services restart with them if the restart
+// replaces their directories, see directoriesReplaced.
+func (s CorpusMinimizeService) minimize(h storage.StorageHandler) (*MinimizationStats, error) {
+	syncDirectory := filepath.Join(constants.LocalSyncDirectory, s.target)
+	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, s.target, "environment"))
+	if err != nil {
+		return nil, err
+	}
+	environment := gotenv.Parse(environmentFile)
+	environmentFile.Close()
+
+	minimizations, err := s.minimizations(environment)
+	if err != nil {
+		return nil, err
+	}
+	toReturn := &MinimizationStats{}
+	for _, m := range minimizations {
+		toReturn.Before += corpusSize(filepath.Join(syncDirectory, m.corpus))
+	}
+
+	err = docker.PauseFuzzers(s.target)
+	if err != nil {
+		docker.UnpauseFuzzers(s.target)
+		return nil, fmt.Errorf("Could not pause fuzzers: %s", err.Error())
+	}
+	for _, m := range minimizations {
+		os.RemoveAll(filepath.Join(syncDirectory, m.minimized))
+		result, err := docker.Minimize(s.target, m.command, minimizeTimeout)
+		if err == nil && (result.TimedOut || result.ExitCode != 0) {
+			err = fmt.Errorf("%s exited with code %d:\n%s", m.command[0], result.ExitCode, result.Stderr)
+		}
+		if err != nil {
+			docker.UnpauseFuzzers(s.target)
+			return nil, err
+		}
+	}
+
+	// Only replace the corpora once every minimization succeeded
+	for _, m := range minimizations {
+		corpus := filepath.Join(syncDirectory, m.corpus)
+		err = replaceCorpus(corpus, filepath.Join(syncDirectory, m.minimized))
+		if err != nil {
+			// Corpora already replaced changed under the fuzzers, so restart
+			// them
+			expectStop(s.target)
+			docker.KillFuzzers(s.target)
+			return nil, fmt.Errorf("Could not replace corpus: %s", err.Error())
+		}
+		toReturn.After += corpusSize(corpus)
+	}
+
+	err = backupTarget(s.target, h)
+	if err != nil {
+		expectStop(s.target)
+		docker.KillFuzzers(s.target)
+		return nil, err
+	}
+	expectStop(s.target)
+	err = docker.KillFuzzers(s.target)
+	if err != nil {
+		return nil, fmt.Errorf("Could not restart fuzzers: %s", err.Error())
+	}
+	toReturn.Time = time.Now().Unix()
+	return toReturn, nil
+}
@@ -0,0 +1,70 @@
+// +build unit
+
+package supervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAFLMinimizations(t *testing.T) {
+	env := map[string]string{
+		"AFL_FUZZ":         "/usr/local/bin/afl/afl-fuzz",
+		"AFL_MEMORY_LIMIT": "none",
+		"AFL_BINARY":       "/root/fuzzer/target",
+	}
+	minimizations, err := aflMinimizations(2)(env)
+	assert.Nil(t, err)
+	assert.Equal(t, []corpusMinimization{
+		{
+			corpus:    "main/queue",
+			minimized: "main/queue_min",
+			command: []string{"/usr/local/bin/afl/afl-cmin", "-i", "/root/fuzz_out/main/queue",
+				"-o", "/root/fuzz_out/main/queue_min", "-m", "none", "--", "/root/fuzzer/target"},
+		},
+		{
+			corpus:    "secondary01/queue",
+			minimized: "secondary01/queue_min",
+			command: []string{"/usr/local/bin/afl/afl-cmin", "-i", "/root/fuzz_out/secondary01/queue",
+				"-o", "/root/fuzz_out/secondary01/queue_min", "-m", "none", "--", "/root/fuzzer/target"},
+		},
+	}, minimizations)
+
+	_, err = aflMinimizations(1)(map[string]string{})
+	assert.NotNil(t, err)
+}
+
+func TestRequestMinimization(t *testing.T) {
+	assert.NotNil(t, RequestMinimization("missing"))
+
+	requests := make(chan bool, 1)
+	minimizeRequests["target"] = requests
+	defer delete(minimizeRequests, "target")
+	assert.Nil(t, RequestMinimization("target"))
+	assert.NotNil(t, RequestMinimization("target"))
+	assert.True(t, <-requests)
+}
+
+func TestReplaceCorpus(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-minimize")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	corpus := filepath.Join(tmp, "queue")
+	minimized := filepath.Join(tmp, "queue_min")
+	writeInput(t, filepath.Join(corpus, "a"), "a")
+	writeInput(t, filepath.Join(corpus, "b"), "b")
+
+	// Nothing to replace it with, so the corpus is left alone
+	assert.NotNil(t, replaceCorpus(corpus, minimized))
+	assert.Equal(t, 2, corpusSize(corpus))
+
+	writeInput(t, filepath.Join(minimized, "a"), "a")
+	assert.Nil(t, replaceCorpus(corpus, minimized))
+	assert.Equal(t, 1, corpusSize(corpus))
+	assert.Equal(t, 0, corpusSize(minimized))
+	assert.Equal(t, 0, corpusSize(corpus+"_old"))
+}
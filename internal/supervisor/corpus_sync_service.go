@@ -0,0 +1,269 @@
+package supervisor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/storage"
+)
+
+// aflSyncPeer is the synthetic AFL instance inputs from other instances of a
+// target are imported as
+var aflSyncPeer = "maxfuzz_sync"
+
+// corpusLayout is where an engine keeps its corpus, relative to the sync
+// directory: the directories new inputs are exported from, the directory
+// inputs from other instances are imported into, "" if they can't be, and
+// what imported inputs are named
+type corpusLayout struct {
+	exports []string
+	imports string
+	name    func(sequence int, hash string) string
+}
+
+// aflCorpusLayout imports inputs into a synthetic peer's queue, which AFL
+// instances pick up as they sync. Single instances run as main instances, so
+// sync too.
+func aflCorpusLayout(instances int) corpusLayout {
+	toReturn := corpusLayout{
+		imports: filepath.Join(aflSyncPeer, "queue"),
+		name: func(sequence int, hash string) string {
+			return fmt.Sprintf("id:%06d,sha256:%s", sequence, hash)
+		},
+	}
+	for i := 0; i < instances; i++ {
+		toReturn.exports = append(toReturn.exports, filepath.Join(aflInstanceName(i), "queue"))
+	}
+	return toReturn
+}
+
+// corpusDirectoryLayout imports inputs straight into a corpus directory.
+// libFuzzer in fork mode and go-fuzz only read their corpus when they start,
+// so they pick imported inputs up when they are next restarted, e.g. by a
+// minimization or a new slot.
+func corpusDirectoryLayout(corpus string) corpusLayout {
+	return corpusLayout{
+		exports: []string{corpus},
+		imports: corpus,
+		name: func(sequence int, hash string) string {
+			return hash
+		},
+	}
+}
+
+type hashedFile struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// corpusSync exchanges a target's corpus with the shared corpus in storage
+type corpusSync struct {
+	directory string // Sync directory of the target
+	layout    corpusLayout
+	hashes    map[string]hashedFile // Hashes of local inputs, by path
+}
+
+func newCorpusSync(target string, layout corpusLayout) *corpusSync {
+	return &corpusSync{
+		directory: filepath.Join(constants.LocalSyncDirectory, target),
+		layout:    layout,
+		hashes:    map[string]hashedFile{},
+	}
+}
+
+// hash returns the SHA-256 of a file's content, only reading files that
+// changed since they were last hashed
+func (c *corpusSync) hash(path string, info os.FileInfo) (string, error) {
+	cached, ok := c.hashes[path]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.hash, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	toReturn := hex.EncodeToString(h.Sum(nil))
+	c.hashes[path] = hashedFile{info.ModTime(), info.Size(), toReturn}
+	return toReturn, nil
+}
+
+// inputs returns the hash of each input in a corpus directory, by name
+func (c *corpusSync) inputs(directory string) (map[string]string, error) {
+	toReturn := map[string]string{}
+	files, err := ioutil.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return toReturn, nil
+	}
+	if err != nil {
+		return toReturn, err
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		hash, err := c.hash(filepath.Join(directory, f.Name()), f)
+		if err != nil {
+			return toReturn, err
+		}
+		toReturn[f.Name()] = hash
+	}
+	return toReturn, nil
+}
+
+// run exports local inputs missing from the shared corpus, then imports
+// shared inputs missing locally. Inputs are deduplicated by hash.
+func (c *corpusSync) run(h storage.StorageHandler) (exported, imported int, err error) {
+	remote, err := h.ListCorpus()
+	if err != nil {
+		return 0, 0, err
+	}
+	shared := map[string]bool{}
+	for _, hash := range remote {
+		shared[hash] = true
+	}
+
+	local := map[string]bool{}
+	for _, d := range c.layout.exports {
+		inputs, err := c.inputs(filepath.Join(c.directory, d))
+		if err != nil {
+			return exported, imported, err
+		}
+		for name, hash := range inputs {
+			local[hash] = true
+			if shared[hash] {
+				continue
+			}
+			err = h.SaveCorpusEntry(hash, filepath.Join(c.directory, d, name))
+			if err != nil {
+				return exported, imported, err
+			}
+			shared[hash] = true
+			exported++
+		}
+	}
+	if c.layout.imports == "" {
+		return exported, imported, nil
+	}
+
+	importDirectory := filepath.Join(c.directory, c.layout.imports)
+	inputs, err := c.inputs(importDirectory)
+	if err != nil {
+		return exported, imported, err
+	}
+	// Imported inputs are numbered on from the last one, as AFL only syncs
+	// inputs with higher ids than those it already has
+	sequence := 0
+	for name, hash := range inputs {
+		local[hash] = true
+		var n int
+		_, err := fmt.Sscanf(name, "id:%06d", &n)
+		if err == nil && n >= sequence {
+			sequence = n + 1
+		}
+	}
+
+	sort.Strings(remote)
+	for _, hash := range remote {
+		if local[hash] {
+			continue
+		}
+		// Downloaded out of the way first, so that fuzzers don't read
+		// partial inputs
+		partial := filepath.Join(c.directory, ".corpus_sync")
+		err = h.GetCorpusEntry(hash, partial)
+		if err == nil {
+			err = os.MkdirAll(importDirectory, 0755)
+		}
+		if err == nil {
+			err = os.Rename(partial, filepath.Join(importDirectory, c.layout.name(sequence, hash)))
+		}
+		if err != nil {
+			return exported, imported, err
+		}
+		local[hash] = true
+		sequence++
+		imported++
+	}
+	return exported, imported, nil
+}
+
+// CorpusSyncService shares a target's corpus between the instances fuzzing it
+// on different hosts, through the target's StorageHandler. A coordinator
+// assigns each target to a single worker, so within a cluster the shared
+// corpus carries a target's progress over to the next worker it is assigned
+// to, including inputs found since its last backup. Separate deployments
+// sharing a storage bucket fuzz the same target side by side, and exchange
+// their inputs.
+type CorpusSyncService struct {
+	logger logging.Logger
+	stop   chan bool
+	target string
+	layout corpusLayout
+}
+
+func NewCorpusSyncService(target string, l logging.Logger, layout corpusLayout) CorpusSyncService {
+	return CorpusSyncService{
+		logger: l,
+		stop:   make(chan bool),
+		target: target,
+		layout: layout,
+	}
+}
+
+func (s CorpusSyncService) Stop() {
+	s.logger.Info("CorpusSyncService stopping")
+	s.stop <- true
+}
+
+func (s CorpusSyncService) Serve() {
+	s.logger.Info("CorpusSyncService starting")
+	storageHandler, err := storage.Init(s.target)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Could not initialize storage client:\n%s", err.Error()))
+		return
+	}
+
+	// An interval of 0 disables corpus sync
+	interval := config.Get().CorpusSyncInterval
+	var schedule <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		schedule = ticker.C
+	}
+
+	corpus := newCorpusSync(s.target, s.layout)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-schedule:
+			exported, imported, err := corpus.run(storageHandler)
+			if err != nil {
+				s.logger.Error(fmt.Sprintf("CorpusSyncService could not sync corpus: %s", err.Error()))
+				continue
+			}
+			if exported > 0 || imported > 0 {
+				s.logger.Info(fmt.Sprintf("CorpusSyncService exported %d and imported %d inputs", exported, imported))
+			}
+		}
+	}
+}
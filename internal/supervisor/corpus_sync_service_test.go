@@ -0,0 +1,77 @@
+// +build unit
+
+package supervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeInput(t *testing.T, path, content string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestCorpusSync(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-corpus")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	config.Set(config.Default())
+	constants.LocalCrashStorage = filepath.Join(tmp, "shared")
+	h, err := storage.Init("target")
+	assert.Nil(t, err)
+
+	// Two hosts fuzzing the same target, with two AFL instances and one
+	constants.LocalSyncDirectory = filepath.Join(tmp, "a")
+	a := newCorpusSync("target", aflCorpusLayout(2))
+	constants.LocalSyncDirectory = filepath.Join(tmp, "b")
+	b := newCorpusSync("target", aflCorpusLayout(1))
+
+	writeInput(t, filepath.Join(a.directory, "main", "queue", "id:000000"), "first")
+	writeInput(t, filepath.Join(a.directory, "secondary01", "queue", "id:000000"), "first")
+	writeInput(t, filepath.Join(a.directory, "secondary01", "queue", "id:000001"), "second")
+	writeInput(t, filepath.Join(b.directory, "main", "queue", "id:000000"), "second")
+
+	exported, imported, err := a.run(h)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 0}, []int{exported, imported})
+
+	exported, imported, err = b.run(h)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, []int{exported, imported})
+	imports, err := b.inputs(filepath.Join(b.directory, aflSyncPeer, "queue"))
+	assert.Nil(t, err)
+	assert.Len(t, imports, 1)
+	for name, hash := range imports {
+		assert.Equal(t, "id:000000,sha256:"+hash, name)
+	}
+
+	// Nothing new
+	exported, imported, err = b.run(h)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 0}, []int{exported, imported})
+
+	writeInput(t, filepath.Join(a.directory, "main", "queue", "id:000001"), "third")
+	_, _, err = a.run(h)
+	assert.Nil(t, err)
+	_, imported, err = b.run(h)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, imported)
+	imports, err = b.inputs(filepath.Join(b.directory, aflSyncPeer, "queue"))
+	assert.Nil(t, err)
+	assert.Len(t, imports, 2)
+	path := filepath.Join(a.directory, "main", "queue", "id:000001")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	third, err := a.hash(path, info)
+	assert.Nil(t, err)
+	assert.Equal(t, third, imports["id:000001,sha256:"+third])
+}
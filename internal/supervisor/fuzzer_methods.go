@@ -2,16 +2,22 @@ package supervisor
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
 	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+	"github.com/everestmz/maxfuzz/internal/types"
 
 	"github.com/go-cmd/cmd"
+	"github.com/howeyc/fsnotify"
 	"github.com/mholt/archiver"
 )
 
@@ -28,14 +34,100 @@ func commandLogger(command *cmd.Cmd, stop chan bool) {
 	}
 }
 
-func initialFuzzerSetup(target string, l logging.Logger, h storage.StorageHandler) (string, error) {
+// crashSource is the target a crash service saves payloads for
+type crashSource struct {
+	target     string // Target ID
+	targetName string
+	engine     string
+	revision   string
+}
+
+func newCrashSource(t *types.Target) crashSource {
+	return crashSource{t.UniqueID, t.Name, t.Engine, t.Revision}
+}
+
+// payload describes a crash input found by an instance of the target's fuzzer
+func (c crashSource) payload(location, instance, category string) storage.FuzzerPayload {
+	return storage.FuzzerPayload{
+		Location:   location,
+		Category:   category,
+		Revision:   c.revision,
+		TargetName: c.targetName,
+		Engine:     c.engine,
+		Image:      docker.ImageID(c.target),
+		Host:       docker.HostName(c.target),
+		Instance:   instance,
+	}
+}
+
+// bucketCrash adds a crash to the bucket matching its output, returning the
+// bucket's signature
+func bucketCrash(b *triage.Buckets, l logging.Logger, output []string, filename, payloadID, category string) string {
+	var size int64
+	info, err := os.Stat(filename)
+	if err == nil {
+		size = info.Size()
+	}
+	sig := triage.Parse(output, filename)
+	bucket, isNew, err := b.Add(sig, category, payloadID, size)
+	if err != nil {
+		l.Error(fmt.Sprintf("Could not bucket crash %s: %s", payloadID, err.Error()))
+		return ""
+	}
+	if bucket == nil {
+		l.Error(fmt.Sprintf("Crash %s was bucketed, but its bucket is missing", payloadID))
+		return ""
+	}
+	if isNew {
+		l.Info(fmt.Sprintf("New %s crash bucket %s: %s", category, bucket.Signature, strings.Join(bucket.Frames, " < ")))
+	}
+	return bucket.Signature
+}
+
+// watchDirectories watches directories for new crashes, returning what each
+// was when watched
+func watchDirectories(w *fsnotify.Watcher, directories []string) (map[string]os.FileInfo, error) {
+	toReturn := map[string]os.FileInfo{}
+	for _, d := range directories {
+		info, err := os.Stat(d)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Watch(d)
+		if err != nil {
+			return nil, err
+		}
+		toReturn[d] = info
+	}
+	return toReturn, nil
+}
+
+// directoriesReplaced reports whether any watched directory was removed or
+// replaced, as when a fuzzer restarts from a backup or AFL resumes, in which
+// case the crash service has to be restarted to watch the new directories
+func directoriesReplaced(watched map[string]os.FileInfo) bool {
+	for d, info := range watched {
+		current, err := os.Stat(d)
+		if err != nil || !os.SameFile(info, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// initialFuzzerSetup downloads the target and restores its sync directory
+// from the last backup. Fuzzer services restarting on the same host pass
+// keepSync, as their sync directory holds findings newer than the backup.
+func initialFuzzerSetup(target string, l logging.Logger, h storage.StorageHandler, keepSync bool) (string, error) {
 	targetDir := filepath.Join(constants.LocalTargetDirectory, target)
 	syncDir := filepath.Join(constants.LocalSyncDirectory, target)
 
 	// Cleanup old stuff
 	os.RemoveAll(targetDir)
 	os.MkdirAll(targetDir, 0775)
-	os.RemoveAll(syncDir)
+	if !keepSync {
+		os.RemoveAll(syncDir)
+	}
 	os.MkdirAll(syncDir, 0775)
 
 	// Download and uncompress fuzzer context
@@ -57,6 +149,10 @@ func initialFuzzerSetup(target string, l logging.Logger, h storage.StorageHandle
 		return "", err
 	}
 
+	if keepSync {
+		return "-i- -o /root/fuzz_out", nil
+	}
+
 	// Check if any backup exists, and use it instead
 	exists, err := h.BackupExists()
 	if err != nil {
@@ -130,6 +226,108 @@ func setupAFLCmd(env map[string]string, aflIoOptions string) ([]string, error) {
 	return nil, fmt.Errorf("Weird AFL_IO_OPTIONS length - is this configured right?")
 }
 
+// setupLibFuzzerCmd runs the fuzzer over the corpus in the sync directory,
+// seeded with the target's corpus. Artifacts (crash-*, leak-*, timeout-* and
+// oom-*) are written to the artifacts directory. The fuzzer runs in fork mode
+// with LIBFUZZER_JOBS jobs (1 by default), so that it keeps going after
+// crashes rather than exiting on the first one.
+func setupLibFuzzerCmd(env map[string]string) ([]string, error) {
+	binary, ok := env["LIBFUZZER_BINARY"]
+	if !ok {
+		return nil, fmt.Errorf("LIBFUZZER_BINARY not populated in environment")
+	}
+	rssLimit, ok := env["LIBFUZZER_RSS_LIMIT"]
+	if !ok {
+		return nil, fmt.Errorf("LIBFUZZER_RSS_LIMIT not populated in environment")
+	}
+
+	jobs := env["LIBFUZZER_JOBS"]
+	if jobs == "" {
+		jobs = "1"
+	}
+
+	toReturn := []string{
+		binary,
+		fmt.Sprintf("-artifact_prefix=%s/", filepath.Join(constants.FuzzerOutputDirectory, libFuzzerArtifacts)),
+		fmt.Sprintf("-rss_limit_mb=%s", rssLimit),
+		fmt.Sprintf("-fork=%s", jobs),
+		"-ignore_crashes=1",
+		"-ignore_timeouts=1",
+		"-ignore_ooms=1",
+	}
+	toReturn = append(toReturn, strings.Fields(env["LIBFUZZER_OPTIONS"])...)
+	return append(toReturn,
+		filepath.Join(constants.FuzzerOutputDirectory, libFuzzerCorpus),
+		"/root/fuzz_in",
+	), nil
+}
+
+// aflInstanceName names the output directory of an AFL instance within the
+// sync directory
+func aflInstanceName(instance int) string {
+	if instance == 0 {
+		return "main"
+	}
+	return fmt.Sprintf("secondary%02d", instance)
+}
+
+// aflInstanceDirectories returns where each AFL instance of a target writes
+// its fuzzer_stats, crashes and hangs. A single instance is a main instance
+// too, so the layout doesn't change with the number of instances.
+func aflInstanceDirectories(target string, instances int) []string {
+	syncDir := filepath.Join(constants.LocalSyncDirectory, target)
+	toReturn := []string{}
+	for i := 0; i < instances; i++ {
+		toReturn = append(toReturn, filepath.Join(syncDir, aflInstanceName(i)))
+	}
+	return toReturn
+}
+
+// migrateAFLLayout moves the output of a single AFL instance restored from a
+// backup taken when single instances wrote straight to the sync directory
+func migrateAFLLayout(target string) error {
+	syncDir := filepath.Join(constants.LocalSyncDirectory, target)
+	mainDir := filepath.Join(syncDir, aflInstanceName(0))
+	if !helpers.Exists(filepath.Join(syncDir, "queue")) || helpers.Exists(mainDir) {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(syncDir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(mainDir, 0775)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = os.Rename(filepath.Join(syncDir, e.Name()), filepath.Join(mainDir, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupAFLCmds returns one command per instance: a -M main instance and -S
+// secondaries, all synced through the output directory
+func setupAFLCmds(env map[string]string, aflIoOptions string, instances int) ([][]string, error) {
+	command, err := setupAFLCmd(env, aflIoOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := [][]string{}
+	for i := 0; i < instances; i++ {
+		role := "-S"
+		if i == 0 {
+			role = "-M"
+		}
+		instanceCommand := []string{command[0], role, aflInstanceName(i)}
+		toReturn = append(toReturn, append(instanceCommand, command[1:]...))
+	}
+	return toReturn, nil
+}
+
 func setupGofuzzCommand(env map[string]string) ([]string, error) {
 	toReturn := []string{"/root/go/bin/go-fuzz"}
 	goFuzzZip, ok := env["GO_FUZZ_ZIP"]
@@ -7,11 +7,14 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/everestmz/maxfuzz/internal/config"
 	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/docker"
-	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
 	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
 	"github.com/everestmz/maxfuzz/internal/types"
 	"github.com/subosito/gotenv"
 
@@ -25,6 +28,7 @@ type GoFuzzerService struct {
 	stop       chan bool
 	baseImage  string
 	statsPort  string
+	resources  types.Resources
 }
 
 var availableHostPorts map[int]bool
@@ -62,11 +66,18 @@ func NewGoFuzzer(target *types.Target, stats chan *TargetStats) *suture.Supervis
 	log := logging.NewTargetLogger(target.Name)
 	ret := New(log, target.Name)
 	statsPort := getAvailablePort()
+	queue := reproduction.NewQueue(target.UniqueID)
+	buckets := triage.NewBuckets(target.UniqueID)
 	ret.Add(NewBackupService(target.UniqueID, log))
-	ret.Add(NewGofuzzStatsService(target.UniqueID, statsPort, log, stats))
-	ret.Add(NewGofuzzCrashService(target.UniqueID, target.Revision, log))
+	ret.Add(NewGofuzzStatsService(target.UniqueID, statsPort, log, stats, buckets))
+	ret.Add(NewGofuzzCrashService(target, log, queue, buckets))
+	ret.Add(NewReproductionService(target.UniqueID, log, queue, buckets))
+	ret.Add(NewCorpusSyncService(target.UniqueID, log, corpusDirectoryLayout("corpus")))
+	// go-fuzz has no merge mode, and doesn't need one: it only adds inputs
+	// that cover new code, minimizing them first, so its corpus grows with
+	// coverage rather than with time. Its corpus isn't minimized.
 	ret.Add(GoFuzzerService{
-		log, target.UniqueID, target.Name, make(chan bool), "fuzzbox_go", statsPort,
+		log, target.UniqueID, target.Name, make(chan bool), "fuzzbox_go", statsPort, target.Resources,
 	})
 	return ret
 }
@@ -79,17 +90,25 @@ func (s GoFuzzerService) Stop() {
 
 func (s GoFuzzerService) Serve() {
 	s.logger.Info(fmt.Sprintf("GoFuzzerService starting"))
+	if waitIfFailed(s.targetID, s.stop, s.logger) || waitIfPaused(s.targetID, s.stop, s.logger) {
+		return
+	}
+	metrics.FuzzerStarted(s.targetID)
+	defer metrics.SetContainerState(s.targetID, metrics.Stopped)
 	storageHandler, err := storage.Init(s.targetID)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not initialize storageHandler: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
 	// Pre-run sync and download steps
+	setLifecycleState(s.targetID, Fetching)
 	s.logger.Info(fmt.Sprintf("GoFuzzerService setting up target"))
-	_, err = initialFuzzerSetup(s.targetID, s.logger, storageHandler)
+	_, err = initialFuzzerSetup(s.targetID, s.logger, storageHandler, false)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GouzzerService could not initialize fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
@@ -97,13 +116,13 @@ func (s GoFuzzerService) Serve() {
 	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, s.targetID, "environment"))
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not parse the environment: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 	environment := gotenv.Parse(environmentFile)
 
 	// Run the build steps
-	opts := helpers.MaxfuzzOptions()
-	suppress := opts["suppressFuzzerOutput"] == "1"
+	suppress := config.Get().SuppressFuzzerOutput
 	stdout := stdoutWriter{
 		suppressOutput: suppress,
 		target:         s.targetName,
@@ -113,34 +132,51 @@ func (s GoFuzzerService) Serve() {
 		target:         s.targetName,
 	}
 	s.logger.Info(fmt.Sprintf("GoFuzzerService running build steps"))
+	setLifecycleState(s.targetID, Building)
+	metrics.SetContainerState(s.targetID, metrics.Building)
+	buildStart := time.Now()
 	config, err := docker.CreateFuzzer(s.targetID, s.baseImage, s.stop, map[string]string{
 		"8000": s.statsPort, // Expose the gofuzz stats port
-	}, stdout, stderr)
+	}, s.resources, stdout, stderr)
 	if err != nil {
+		if err == docker.ErrOOMKilled {
+			recordOOMKill(s.targetID)
+		}
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not build the fuzzer: %s", err.Error()))
+		if !stopped(err) {
+			lifecycleFailed(s.targetID, err)
+		}
 		return
 	}
 
+	metrics.BuildFinished(s.targetID, time.Since(buildStart))
+
 	// Finally, run the fuzzer
 	s.logger.Info(fmt.Sprintf("GoFuzzerService running fuzzer"))
 	command, err := setupGofuzzCommand(environment)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not set up the fuzz command: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
-	fuzzCluster, err := config.Deploy(command, stdout, stderr)
+	fuzzCluster, err := config.Deploy([][]string{command}, stdout, stderr)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
 
 	clusterState, err := fuzzCluster.State()
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("GoFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
 		return
 	}
+	metrics.SetContainerState(s.targetID, metrics.Running)
+	setLifecycleState(s.targetID, Fuzzing)
 
+	frozen := false
 	ticker := time.NewTicker(time.Second)
 	for {
 		select {
@@ -156,15 +192,27 @@ func (s GoFuzzerService) Serve() {
 			clusterState, err = fuzzCluster.State()
 			if err != nil {
 				s.logger.Error(fmt.Sprintf("GoFuzzerService could not start the fuzzer: %s", err.Error()))
+				lifecycleFailed(s.targetID, err)
 				return
 			}
 			if !clusterState.Running() {
-				s.logger.Error(
-					fmt.Sprintf(
-						"GoFuzzerService fuzz cluster stopped unexpectedly\nErrors: %s\nExit code: %v",
-						err.Error(), clusterState.ExitCode()))
+				if stopExpected(s.targetID, s.stop) {
+					s.logger.Info("GoFuzzerService fuzzers were stopped, restarting")
+				} else if clusterState.OOMKilled() {
+					recordOOMKill(s.targetID)
+					s.logger.Error("GoFuzzerService fuzz cluster ran out of memory")
+					lifecycleFailed(s.targetID, fmt.Errorf("Fuzzer ran out of memory"))
+				} else {
+					s.logger.Error(
+						fmt.Sprintf(
+							"GoFuzzerService fuzz cluster stopped unexpectedly\nExit code: %v",
+							clusterState.ExitCode()))
+					lifecycleFailed(s.targetID, fmt.Errorf("Fuzzer exited with code %v", clusterState.ExitCode()))
+				}
+				fuzzCluster.Kill()
 				return
 			}
+			frozen = followPause(s.targetID, frozen, storageHandler, s.logger)
 		}
 	}
 }
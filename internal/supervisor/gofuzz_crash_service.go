@@ -2,30 +2,39 @@ package supervisor
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/everestmz/maxfuzz/internal/constants"
 	"github.com/everestmz/maxfuzz/internal/helpers"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
 	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+	"github.com/everestmz/maxfuzz/internal/types"
 
 	"github.com/howeyc/fsnotify"
+	"github.com/subosito/gotenv"
 )
 
 type GofuzzCrashService struct {
-	logger   logging.Logger
-	stop     chan bool
-	target   string
-	revision string
+	crashSource
+	logger  logging.Logger
+	stop    chan bool
+	queue   reproduction.Queue
+	buckets *triage.Buckets
 }
 
-func NewGofuzzCrashService(target, revision string, l logging.Logger) GofuzzCrashService {
+func NewGofuzzCrashService(target *types.Target, l logging.Logger, queue reproduction.Queue, buckets *triage.Buckets) GofuzzCrashService {
 	return GofuzzCrashService{
-		logger:   l,
-		stop:     make(chan bool),
-		target:   target,
-		revision: revision,
+		crashSource: newCrashSource(target),
+		logger:      l,
+		stop:        make(chan bool),
+		queue:       queue,
+		buckets:     buckets,
 	}
 }
 
@@ -38,6 +47,7 @@ func (s GofuzzCrashService) Serve() {
 	s.logger.Info("GofuzzCrashService starting")
 	watcher, err := fsnotify.NewWatcher()
 	panicOnError(err)
+	defer watcher.Close()
 
 	storageHandler, err := storage.Init(s.target)
 	if err != nil {
@@ -45,42 +55,127 @@ func (s GofuzzCrashService) Serve() {
 		return
 	}
 
+	// go-fuzz reproduces crashers itself, writing the result to .output files.
+	// Only queue crashers for reproduction if the target asks for it.
+	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, s.target, "environment"))
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("GofuzzCrashService could not parse the environment: %s", err.Error()))
+		return
+	}
+	_, reproduce := gotenv.Parse(environmentFile)["REPRODUCE_COMMAND"]
+	environmentFile.Close()
+
+	// go-fuzz writes to the fuzzer output directory, which is the target's
+	// sync directory on the host
+	crashDirectory := filepath.Join(constants.LocalSyncDirectory, s.target, "crashers")
 	s.logger.Info("GofuzzCrashService waiting for crash directories")
 	exists := false
 	for !exists {
-		exists = helpers.Exists(filepath.Join(constants.FuzzerOutputDirectory, "crashers"))
+		exists = helpers.Exists(crashDirectory)
 	}
 
-	err = watcher.Watch(filepath.Join(constants.FuzzerOutputDirectory, "crashers"))
+	watched, err := watchDirectories(watcher, []string{crashDirectory})
 	panicOnError(err)
 
+	// go-fuzz writes <hash>, <hash>.quoted and <hash>.output for every crasher.
+	// Payload IDs are kept by hash so outputs can be linked to their payload.
+	payloadIDs := map[string]string{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case ev := <-watcher.Event:
-			if ev.IsCreate() {
-				s.logger.Info("GoFuzzCrashService: Bug found")
-				if strings.Contains(ev.Name, ".output") {
-					// This is the output of a crash
-					// TODO: Parse crash output
-				} else {
-					// This is a crash payload
-					payload := storage.FuzzerPayload{
-						Location: ev.Name,
-						Category: "CRASH",
-						Revision: s.revision,
-					}
-					crashID := filepath.Base(ev.Name)
-					s.logger.Info(fmt.Sprintf("Bug found: %s", crashID))
-					_, err = storageHandler.SavePayload(payload)
+			switch {
+			case strings.HasSuffix(ev.Name, ".quoted"):
+				// Saved alongside the output
+			case strings.HasSuffix(ev.Name, ".output"):
+				// This is the output of a crash, which may be written to after
+				// being created
+				if !ev.IsCreate() && !ev.IsModify() {
+					continue
+				}
+				hash := strings.TrimSuffix(filepath.Base(ev.Name), ".output")
+				payloadID, ok := payloadIDs[hash]
+				if !ok {
+					s.logger.Error(fmt.Sprintf("GofuzzCrashService found output for unknown crash %s", hash))
+					continue
+				}
+				output, err := gofuzzOutput(strings.TrimSuffix(ev.Name, ".output"), payloadID)
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("GofuzzCrashService could not read crash output: %s", err.Error()))
+					continue
+				}
+				if len(output.Output) == 0 {
+					continue
+				}
+				output.Revision = s.revision
+				if !reproduce {
+					output.Bucket = bucketCrash(s.buckets, s.logger, output.Output, strings.TrimSuffix(ev.Name, ".output"), payloadID, output.Category)
+				}
+				err = storageHandler.SaveOutput(output)
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("GofuzzCrashService could not save crash output: %s", err.Error()))
+				}
+				if !reproduce {
+					err = storage.SetReproduction(storageHandler, s.target, payloadID, storage.Reproduced, output.Category, output.Bucket)
 					if err != nil {
-						s.logger.Error(fmt.Sprintf("GofuzzCrashService Could not save bug payload: %s", err.Error()))
+						s.logger.Error(fmt.Sprintf("GofuzzCrashService could not update crash metadata: %s", err.Error()))
 					}
 				}
+			case ev.IsCreate():
+				// This is a crash payload
+				payload := s.payload(ev.Name, "", triage.Crash)
+				crashID := filepath.Base(ev.Name)
+				s.logger.Info(fmt.Sprintf("Bug found: %s", crashID))
+				payloadID, err := storageHandler.SavePayload(payload)
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("GofuzzCrashService Could not save bug payload: %s", err.Error()))
+					continue
+				}
+				payloadIDs[crashID] = payloadID
+				if !reproduce {
+					continue
+				}
+				err = s.queue.Produce(reproduction.Crash{
+					Filename:  ev.Name,
+					Kind:      payload.Category,
+					Target:    s.target,
+					PayloadID: payloadID,
+					Revision:  s.revision,
+				})
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("GofuzzCrashService Could not queue bug for reproduction: %s", err.Error()))
+				}
 			}
 		case err := <-watcher.Error:
 			s.logger.Error(fmt.Sprintf("GofuzzCrashService: %s", err.Error()))
+		case <-ticker.C:
+			if directoriesReplaced(watched) {
+				s.logger.Info("GofuzzCrashService crash directory replaced, restarting")
+				return
+			}
 		case <-s.stop:
 			return
 		}
 	}
 }
+
+// gofuzzOutput collects the .output and .quoted files go-fuzz writes next to
+// a crasher
+func gofuzzOutput(crasher, payloadID string) (storage.FuzzerPayloadOutput, error) {
+	toReturn := storage.FuzzerPayloadOutput{Identifier: payloadID}
+	output, err := ioutil.ReadFile(fmt.Sprintf("%s.output", crasher))
+	if err != nil {
+		return toReturn, err
+	}
+	toReturn.Output = splitLines(output)
+	classification := triage.Classify(toReturn.Output, 0, false, crasher)
+	toReturn.Category = classification.Category
+	toReturn.Signal = classification.Signal
+
+	quoted, err := ioutil.ReadFile(fmt.Sprintf("%s.quoted", crasher))
+	if err == nil {
+		toReturn.Input = strings.TrimSpace(string(quoted))
+	}
+	return toReturn, nil
+}
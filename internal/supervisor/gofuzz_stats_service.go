@@ -10,13 +10,18 @@ import (
 	"time"
 
 	sse "astuart.co/go-sse"
+	"github.com/everestmz/maxfuzz/internal/docker"
 	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/triage"
 )
 
 type GoFuzzStats struct {
-	Crashers int    `json:"Crashers"`
-	Execs    int    `json:"Execs"`
-	Uptime   string `json:"Uptime"`
+	Corpus           int       `json:"Corpus"`
+	Crashers         int       `json:"Crashers"`
+	Execs            int       `json:"Execs"`
+	Cover            int       `json:"Cover"`
+	Uptime           string    `json:"Uptime"`
+	LastNewInputTime time.Time `json:"LastNewInputTime"`
 }
 
 type GofuzzStatsService struct {
@@ -25,15 +30,17 @@ type GofuzzStatsService struct {
 	stats     chan *TargetStats
 	target    string
 	statsPort string
+	buckets   *triage.Buckets
 }
 
-func NewGofuzzStatsService(target, statsPort string, l logging.Logger, statsChan chan *TargetStats) GofuzzStatsService {
+func NewGofuzzStatsService(target, statsPort string, l logging.Logger, statsChan chan *TargetStats, buckets *triage.Buckets) GofuzzStatsService {
 	return GofuzzStatsService{
 		logger:    l,
 		stop:      make(chan bool),
 		target:    target,
 		stats:     statsChan,
 		statsPort: statsPort,
+		buckets:   buckets,
 	}
 }
 
@@ -50,7 +57,8 @@ func (s GofuzzStatsService) Serve() {
 	go func() {
 		for {
 			time.Sleep(time.Second)
-			sse.Notify(fmt.Sprintf("http://0.0.0.0:%s/eventsource", s.statsPort), evCh)
+			// The fuzzer publishes its stats port on the host it was placed on
+			sse.Notify(fmt.Sprintf("http://%s:%s/eventsource", docker.HostAddress(s.target), s.statsPort), evCh)
 		}
 	}()
 
@@ -108,10 +116,20 @@ func (s GofuzzStatsService) Serve() {
 				secsRunning = secs
 			}
 			log.Println(fmt.Sprintf("%v execs, %v secs", newStats.Execs, secsRunning))
+			sinceLastPath := int64(secsRunning)
+			if !newStats.LastNewInputTime.IsZero() {
+				sinceLastPath = int64(time.Since(newStats.LastNewInputTime) / time.Second)
+			}
 			commonStats := TargetStats{
 				ID:             s.target,
-				BugsFound:      newStats.Crashers,
+				BugsFound:      s.buckets.Count(),
+				Crashes:        newStats.Crashers,
 				TestsPerSecond: float64(newStats.Execs) / float64(secsRunning),
+				TotalExecs:     int64(newStats.Execs),
+				CorpusSize:     newStats.Corpus,
+				Coverage:       float64(newStats.Cover),
+				SinceLastPath:  sinceLastPath,
+				Uptime:         int64(secsRunning),
 			}
 			s.stats <- &commonStats
 		}
@@ -0,0 +1,110 @@
+package supervisor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/helpers"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
+	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/howeyc/fsnotify"
+)
+
+type LibFuzzerCrashService struct {
+	crashSource
+	logger logging.Logger
+	stop   chan bool
+	queue  reproduction.Queue
+}
+
+func NewLibFuzzerCrashService(target *types.Target, l logging.Logger, queue reproduction.Queue) LibFuzzerCrashService {
+	return LibFuzzerCrashService{
+		crashSource: newCrashSource(target),
+		logger:      l,
+		stop:        make(chan bool),
+		queue:       queue,
+	}
+}
+
+func (s LibFuzzerCrashService) Stop() {
+	s.logger.Info("LibFuzzerCrashService stopping")
+	s.stop <- true
+}
+
+func isLibFuzzerArtifact(filename string) bool {
+	for _, prefix := range libFuzzerArtifactPrefixes {
+		if strings.HasPrefix(filepath.Base(filename), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s LibFuzzerCrashService) Serve() {
+	s.logger.Info("LibFuzzerCrashService starting")
+	storageHandler, err := storage.Init(s.target)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Could not initialize storage client:\n%s", err.Error()))
+		return
+	}
+
+	artifactsDirectory := filepath.Join(constants.LocalSyncDirectory, s.target, libFuzzerArtifacts)
+	watcher, err := fsnotify.NewWatcher()
+	panicOnError(err)
+	defer watcher.Close()
+
+	// Created by the LibFuzzerService before the fuzzer starts
+	s.logger.Info("LibFuzzerCrashService waiting for artifacts directory")
+	for !helpers.Exists(artifactsDirectory) {
+	}
+	watched, err := watchDirectories(watcher, []string{artifactsDirectory})
+	panicOnError(err)
+
+	s.logger.Info("LibFuzzerCrashService watching artifacts directory")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-watcher.Event:
+			if ev.IsCreate() && isLibFuzzerArtifact(ev.Name) {
+				crashID := filepath.Base(ev.Name)
+				s.logger.Info(fmt.Sprintf("Bug found: %s", crashID))
+				// Refined by the reproduction service once the crash is
+				// reproduced
+				classification := triage.Classify([]string{}, 0, false, ev.Name)
+				payload := s.payload(ev.Name, "", classification.Category)
+				payloadID, err := storageHandler.SavePayload(payload)
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("LibFuzzerCrashService Could not save bug payload: %s", err.Error()))
+					continue
+				}
+				err = s.queue.Produce(reproduction.Crash{
+					Filename:  ev.Name,
+					Kind:      payload.Category,
+					Target:    s.target,
+					PayloadID: payloadID,
+					Revision:  s.revision,
+				})
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("LibFuzzerCrashService Could not queue bug for reproduction: %s", err.Error()))
+				}
+			}
+		case err := <-watcher.Error:
+			s.logger.Error(fmt.Sprintf("LibFuzzerCrashService: %s", err.Error()))
+		case <-ticker.C:
+			if directoriesReplaced(watched) {
+				s.logger.Info("LibFuzzerCrashService artifacts directory replaced, restarting")
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
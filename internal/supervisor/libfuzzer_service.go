@@ -0,0 +1,236 @@
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
+	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+	"github.com/everestmz/maxfuzz/internal/types"
+
+	"github.com/subosito/gotenv"
+	"github.com/thejerf/suture"
+)
+
+// Directories within the sync directory
+var libFuzzerCorpus = "corpus"
+var libFuzzerArtifacts = "artifacts"
+
+type LibFuzzerService struct {
+	logger     logging.Logger
+	targetID   string
+	targetName string
+	stop       chan bool
+	baseImage  string
+	lines      chan string // Fuzzer output, read by the LibFuzzerStatsService
+	resources  types.Resources
+	setUp      *bool // Whether the sync directory was set up by an earlier run
+}
+
+func NewLibFuzzer(target *types.Target, stats chan *TargetStats) *suture.Supervisor {
+	log := logging.NewTargetLogger(target.Name)
+	ret := New(log, target.Name)
+	lines := make(chan string, 100)
+	queue := reproduction.NewQueue(target.UniqueID)
+	buckets := triage.NewBuckets(target.UniqueID)
+	ret.Add(NewBackupService(target.UniqueID, log))
+	ret.Add(NewLibFuzzerStatsService(target.UniqueID, log, lines, stats, buckets))
+	ret.Add(NewLibFuzzerCrashService(target, log, queue))
+	ret.Add(NewReproductionService(target.UniqueID, log, queue, buckets))
+	ret.Add(NewCorpusMinimizeService(target.UniqueID, log, libFuzzerMinimizations))
+	ret.Add(NewCorpusSyncService(target.UniqueID, log, corpusDirectoryLayout(libFuzzerCorpus)))
+	ret.Add(LibFuzzerService{
+		log,
+		target.UniqueID,
+		target.Name,
+		make(chan bool),
+		"fuzzbox_c",
+		lines,
+		target.Resources,
+		new(bool),
+	})
+	return ret
+}
+
+func (s LibFuzzerService) Stop() {
+	s.logger.Info("LibFuzzerService stopping")
+	s.stop <- true
+	s.logger.Info("LibFuzzerService stopped")
+}
+
+func (s LibFuzzerService) Serve() {
+	s.logger.Info("LibFuzzerService starting")
+	if waitIfFailed(s.targetID, s.stop, s.logger) || waitIfPaused(s.targetID, s.stop, s.logger) {
+		return
+	}
+	metrics.FuzzerStarted(s.targetID)
+	defer metrics.SetContainerState(s.targetID, metrics.Stopped)
+	storageHandler, err := storage.Init(s.targetID)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not initialize storageHandler: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+
+	// Pre-run sync and download steps
+	setLifecycleState(s.targetID, Fetching)
+	s.logger.Info("LibFuzzerService setting up target")
+	_, err = initialFuzzerSetup(s.targetID, s.logger, storageHandler, *s.setUp)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not initialize fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+	// Restarts keep the corpus and any artifacts not yet picked up by the
+	// LibFuzzerCrashService, which keeps watching the artifacts directory
+	*s.setUp = true
+	// libFuzzer doesn't create its output directories
+	for _, d := range []string{libFuzzerCorpus, libFuzzerArtifacts} {
+		err = os.MkdirAll(filepath.Join(constants.LocalSyncDirectory, s.targetID, d), 0775)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("LibFuzzerService could not create %s directory: %s", d, err.Error()))
+			lifecycleFailed(s.targetID, err)
+			return
+		}
+	}
+
+	// Get environment
+	environmentFile, err := os.Open(filepath.Join(constants.LocalTargetDirectory, s.targetID, "environment"))
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not parse the environment: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+	environment := gotenv.Parse(environmentFile)
+
+	// Run the build steps
+	suppress := config.Get().SuppressFuzzerOutput
+	stdout := stdoutWriter{
+		suppressOutput: suppress,
+		target:         s.targetName,
+	}
+	stderr := stderrWriter{
+		suppressOutput: suppress,
+		target:         s.targetName,
+	}
+	s.logger.Info("LibFuzzerService running build steps")
+	setLifecycleState(s.targetID, Building)
+	metrics.SetContainerState(s.targetID, metrics.Building)
+	buildStart := time.Now()
+	config, err := docker.CreateFuzzer(s.targetID, s.baseImage, s.stop, map[string]string{}, s.resources, stdout, stderr)
+	if err != nil {
+		if err == docker.ErrOOMKilled {
+			recordOOMKill(s.targetID)
+		}
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not build the fuzzer: %s", err.Error()))
+		if !stopped(err) {
+			lifecycleFailed(s.targetID, err)
+		}
+		return
+	}
+	metrics.BuildFinished(s.targetID, time.Since(buildStart))
+
+	// Finally, run the fuzzer
+	s.logger.Info("LibFuzzerService running fuzzer")
+	command, err := setupLibFuzzerCmd(environment)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not set up the fuzz command: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+
+	// libFuzzer reports its status on stderr
+	fuzzCluster, err := config.Deploy([][]string{command}, stdout, &lineWriter{w: stderr, lines: s.lines})
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+
+	clusterState, err := fuzzCluster.State()
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("LibFuzzerService could not start the fuzzer: %s", err.Error()))
+		lifecycleFailed(s.targetID, err)
+		return
+	}
+	metrics.SetContainerState(s.targetID, metrics.Running)
+	setLifecycleState(s.targetID, Fuzzing)
+
+	frozen := false
+	ticker := time.NewTicker(time.Second)
+	for {
+		select {
+		case <-s.stop:
+			s.logger.Info("LibFuzzerService spinning down fuzzer")
+			ticker.Stop()
+			err = fuzzCluster.Kill()
+			if err != nil {
+				s.logger.Error(fmt.Sprintf("LibFuzzerService could not spin down the fuzzer: %s", err.Error()))
+			}
+			return
+		case <-ticker.C:
+			clusterState, err = fuzzCluster.State()
+			if err != nil {
+				s.logger.Error(fmt.Sprintf("LibFuzzerService could not start the fuzzer: %s", err.Error()))
+				lifecycleFailed(s.targetID, err)
+				return
+			}
+			if !clusterState.Running() {
+				if stopExpected(s.targetID, s.stop) {
+					s.logger.Info("LibFuzzerService fuzzers were stopped, restarting")
+				} else if clusterState.OOMKilled() {
+					recordOOMKill(s.targetID)
+					s.logger.Error("LibFuzzerService fuzzer ran out of memory")
+					lifecycleFailed(s.targetID, fmt.Errorf("Fuzzer ran out of memory"))
+				} else {
+					// Fork mode keeps going after crashes, so the fuzzer was
+					// killed from outside maxfuzz
+					s.logger.Error(
+						fmt.Sprintf(
+							"LibFuzzerService fuzzer stopped\nExit code: %v",
+							clusterState.ExitCode()))
+					setLifecycleState(s.targetID, Backoff)
+				}
+				fuzzCluster.Kill()
+				return
+			}
+			frozen = followPause(s.targetID, frozen, storageHandler, s.logger)
+		}
+	}
+}
+
+// lineWriter passes complete lines on to lines as well as writing them to w.
+// Lines are dropped if nobody is reading them.
+type lineWriter struct {
+	w     io.Writer
+	lines chan string
+	buf   bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Incomplete, keep it for the next write
+			w.buf.WriteString(line)
+			break
+		}
+		select {
+		case w.lines <- strings.TrimRight(line, "\r\n"):
+		default:
+		}
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,123 @@
+package supervisor
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/constants"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/triage"
+)
+
+// Matches status lines like
+// #1048576	pulse  cov: 3 ft: 3 corp: 1/1b lim: 4096 exec/s: 349525 rss: 26Mb
+// and, in fork mode, which has no events,
+// #524288: cov: 3 ft: 3 corp: 1 exec/s: 174762 oom/timeout/crash: 0/0/1 time: 3s job: 2 dft_time: 0
+var libFuzzerStatusLine = regexp.MustCompile(`^#(\d+):?\s+(?:(\w+)\s)?.*\bexec/s: (\d+)`)
+var libFuzzerCoverage = regexp.MustCompile(`\bcov: (\d+)`)
+var libFuzzerCorpusSize = regexp.MustCompile(`\bcorp: (\d+)\b`)
+
+var libFuzzerArtifactPrefixes = []string{"crash-", "leak-", "timeout-", "oom-"}
+
+type libFuzzerStatus struct {
+	Execs          int64
+	Event          string // INITED, NEW, REDUCE, pulse, DONE... Empty in fork mode
+	Coverage       int
+	Corpus         int
+	ExecsPerSecond float64
+}
+
+func parseLibFuzzerStatus(line string) (libFuzzerStatus, bool) {
+	toReturn := libFuzzerStatus{}
+	match := libFuzzerStatusLine.FindStringSubmatch(line)
+	if match == nil {
+		return toReturn, false
+	}
+	toReturn.Execs, _ = strconv.ParseInt(match[1], 10, 64)
+	toReturn.Event = match[2]
+	toReturn.ExecsPerSecond, _ = strconv.ParseFloat(match[3], 64)
+	if cov := libFuzzerCoverage.FindStringSubmatch(line); cov != nil {
+		toReturn.Coverage, _ = strconv.Atoi(cov[1])
+	}
+	if corp := libFuzzerCorpusSize.FindStringSubmatch(line); corp != nil {
+		toReturn.Corpus, _ = strconv.Atoi(corp[1])
+	}
+	return toReturn, true
+}
+
+type LibFuzzerStatsService struct {
+	logger  logging.Logger
+	stop    chan bool
+	lines   chan string
+	stats   chan *TargetStats
+	target  string
+	buckets *triage.Buckets
+}
+
+func NewLibFuzzerStatsService(target string, l logging.Logger, lines chan string, statsChan chan *TargetStats, buckets *triage.Buckets) LibFuzzerStatsService {
+	return LibFuzzerStatsService{
+		logger:  l,
+		stop:    make(chan bool),
+		lines:   lines,
+		stats:   statsChan,
+		target:  target,
+		buckets: buckets,
+	}
+}
+
+func (s LibFuzzerStatsService) Stop() {
+	s.logger.Info("LibFuzzerStatsService stopping")
+	s.stop <- true
+}
+
+func (s LibFuzzerStatsService) Serve() {
+	s.logger.Info("LibFuzzerStatsService watching statistics")
+	artifactsDirectory := filepath.Join(constants.LocalSyncDirectory, s.target, libFuzzerArtifacts)
+	var latest *libFuzzerStatus
+	started := time.Now()
+	lastPath := started
+	ticker := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-s.stop:
+			ticker.Stop()
+			return
+		case line := <-s.lines:
+			status, ok := parseLibFuzzerStatus(line)
+			if ok {
+				// Fork mode only reports the corpus size
+				if status.Event == "NEW" || (latest != nil && status.Corpus > latest.Corpus) {
+					lastPath = time.Now()
+				}
+				latest = &status
+			}
+		case <-ticker.C:
+			if latest == nil {
+				continue
+			}
+			crashes := 0
+			for _, prefix := range libFuzzerArtifactPrefixes {
+				artifacts, err := filepath.Glob(filepath.Join(artifactsDirectory, prefix+"*"))
+				if err != nil {
+					s.logger.Error(fmt.Sprintf("LibFuzzerStatsService could not count artifacts: %s", err.Error()))
+					return
+				}
+				crashes += len(artifacts)
+			}
+			s.stats <- &TargetStats{
+				ID:             s.target,
+				TestsPerSecond: latest.ExecsPerSecond,
+				BugsFound:      s.buckets.Count(),
+				Crashes:        crashes,
+				TotalExecs:     latest.Execs,
+				CorpusSize:     latest.Corpus,
+				Coverage:       float64(latest.Coverage),
+				SinceLastPath:  int64(time.Since(lastPath) / time.Second),
+				Uptime:         int64(time.Since(started) / time.Second),
+			}
+		}
+	}
+}
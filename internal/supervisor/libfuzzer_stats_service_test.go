@@ -0,0 +1,52 @@
+// +build unit
+
+package supervisor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLibFuzzerStatus(t *testing.T) {
+	status, ok := parseLibFuzzerStatus("#1048576\tpulse  cov: 3 ft: 3 corp: 1/1b lim: 4096 exec/s: 349525 rss: 26Mb")
+	assert.True(t, ok)
+	assert.Equal(t, libFuzzerStatus{
+		Execs:          1048576,
+		Event:          "pulse",
+		Coverage:       3,
+		Corpus:         1,
+		ExecsPerSecond: 349525,
+	}, status)
+
+	status, ok = parseLibFuzzerStatus("#12\tNEW    cov: 4 ft: 4 corp: 2/3b exec/s: 0 rss: 26Mb L: 2 MS: 1 ChangeBit-")
+	assert.True(t, ok)
+	assert.Equal(t, "NEW", status.Event)
+	assert.Equal(t, 2, status.Corpus)
+
+	status, ok = parseLibFuzzerStatus("#524288: cov: 3 ft: 3 corp: 7 exec/s: 174762 oom/timeout/crash: 0/0/1 time: 3s job: 2 dft_time: 0")
+	assert.True(t, ok)
+	assert.Equal(t, libFuzzerStatus{
+		Execs:          524288,
+		Coverage:       3,
+		Corpus:         7,
+		ExecsPerSecond: 174762,
+	}, status)
+
+	_, ok = parseLibFuzzerStatus("INFO: Seed: 1608565063")
+	assert.False(t, ok)
+}
+
+func TestLineWriter(t *testing.T) {
+	var out bytes.Buffer
+	lines := make(chan string, 10)
+	w := &lineWriter{w: &out, lines: lines}
+	w.Write([]byte("#1\tINITED exec/s: 0\n#2\tNE"))
+	w.Write([]byte("W exec/s: 0\n"))
+
+	assert.Equal(t, "#1\tINITED exec/s: 0\n#2\tNEW exec/s: 0\n", out.String())
+	assert.Equal(t, "#1\tINITED exec/s: 0", <-lines)
+	assert.Equal(t, "#2\tNEW exec/s: 0", <-lines)
+	assert.Equal(t, 0, len(lines))
+}
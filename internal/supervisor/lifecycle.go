@@ -0,0 +1,224 @@
+package supervisor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Lifecycle states of targets
+const (
+	Registered = "REGISTERED" // Not started yet
+	Fetching   = "FETCHING"   // Downloading the target and its last backup
+	Building   = "BUILDING"   // Running the build steps
+	Fuzzing    = "FUZZING"
+	BackingUp  = "BACKING_UP"
+	Backoff    = "BACKOFF" // Waiting to be restarted after a failure
+	Failed     = "FAILED"  // Failed too many times in a row to be retried
+	Paused     = "PAUSED"
+	Queued     = "QUEUED" // Waiting to be scheduled
+)
+
+// Lifecycle is where a target is in its lifecycle, driven by its fuzzer
+// service. Timestamps are unix times.
+type Lifecycle struct {
+	State               string `json:"state"`
+	Since               int64  `json:"since"` // When the target entered its state
+	Registered          int64  `json:"registered"`
+	Failures            int    `json:"failures"`
+	ConsecutiveFailures int    `json:"consecutive_failures"` // Since the target last started fuzzing
+	LastError           string `json:"last_error"`
+	LastFailure         int64  `json:"last_failure"`
+
+	paused       bool // Paused through the API, see PauseTarget
+	expectedStop bool // Fuzzers stopped on purpose, see expectStop
+}
+
+var lifecycles = map[string]*Lifecycle{}
+var lifecycleLock sync.Mutex
+
+// persistLifecycle saves the lifecycle of a target when its registration or
+// failures change, see PersistLifecycles
+var persistLifecycle = func(target string, l Lifecycle) {}
+
+// PersistLifecycles has persist called with a copy of a target's lifecycle
+// whenever it is registered or its failures change, so that failed targets
+// stay failed across restarts
+func PersistLifecycles(persist func(target string, l Lifecycle)) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	persistLifecycle = persist
+}
+
+// lifecycle returns the lifecycle of a target, which must be called with the
+// lock held. Targets fuzzed for a coordinator aren't tracked until they start.
+func lifecycle(target string) *Lifecycle {
+	l, ok := lifecycles[target]
+	if !ok {
+		now := time.Now().Unix()
+		l = &Lifecycle{State: Registered, Since: now, Registered: now}
+		lifecycles[target] = l
+	}
+	return l
+}
+
+func (l *Lifecycle) set(state string) {
+	if l.State != state {
+		l.State = state
+		l.Since = time.Now().Unix()
+	}
+}
+
+// Unscheduled reports a target the scheduler isn't running as queued, or as
+// paused if it was paused while queued. Failed and paused targets keep their
+// state.
+func (l *Lifecycle) Unscheduled(paused bool) {
+	if l.State == Failed || l.State == Paused {
+		return
+	}
+	if paused {
+		l.State = Paused
+	} else {
+		l.State = Queued
+	}
+}
+
+// TrackLifecycle starts the lifecycle of a newly registered target, or carries
+// on with its restored lifecycle if it was persisted. Restored targets start
+// over from REGISTERED, unless they had failed.
+func TrackLifecycle(target string, restored *Lifecycle) {
+	lifecycleLock.Lock()
+	delete(lifecycles, target)
+	l := lifecycle(target)
+	if restored != nil {
+		l.Registered = restored.Registered
+		l.Failures = restored.Failures
+		l.ConsecutiveFailures = restored.ConsecutiveFailures
+		l.LastError = restored.LastError
+		l.LastFailure = restored.LastFailure
+		if restored.State == Failed {
+			l.State = Failed
+		}
+	}
+	saved, persist := *l, persistLifecycle
+	lifecycleLock.Unlock()
+	persist(target, saved)
+}
+
+func ForgetLifecycle(target string) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	delete(lifecycles, target)
+}
+
+// TargetLifecycle returns a copy of a target's lifecycle
+func TargetLifecycle(target string) Lifecycle {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l, ok := lifecycles[target]
+	if !ok {
+		return Lifecycle{State: Registered}
+	}
+	return *l
+}
+
+func setLifecycleState(target, state string) {
+	lifecycleLock.Lock()
+	l := lifecycle(target)
+	reset := state == Fuzzing && l.ConsecutiveFailures > 0
+	if state == Fuzzing {
+		l.ConsecutiveFailures = 0
+		l.expectedStop = false
+	}
+	l.set(state)
+	saved, persist := *l, persistLifecycle
+	lifecycleLock.Unlock()
+	if reset {
+		persist(target, saved)
+	}
+}
+
+// swapLifecycleState moves a target from one state to another, returning
+// false if it wasn't in the first
+func swapLifecycleState(target, from, to string) bool {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l := lifecycle(target)
+	if l.State != from {
+		return false
+	}
+	l.set(to)
+	return true
+}
+
+// lifecycleFailed records a failure of a target's fuzzer service, which is
+// retried after a backoff unless the target failed config.MaxFailures times
+// without starting to fuzz
+func lifecycleFailed(target string, err error) {
+	lifecycleLock.Lock()
+	l := lifecycle(target)
+	l.Failures++
+	l.ConsecutiveFailures++
+	l.LastError = err.Error()
+	l.LastFailure = time.Now().Unix()
+	max := config.Get().MaxFailures
+	if max > 0 && l.ConsecutiveFailures >= max {
+		l.set(Failed)
+	} else {
+		l.set(Backoff)
+	}
+	saved, persist := *l, persistLifecycle
+	lifecycleLock.Unlock()
+	persist(target, saved)
+}
+
+// expectStop marks the next stop of a target's fuzzers as intended, e.g. to
+// restart them on a minimized corpus, so that it isn't counted as a failure
+func expectStop(target string) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	lifecycle(target).expectedStop = true
+}
+
+// stopExpected reports whether a target's fuzzers were stopped on purpose,
+// either through expectStop or as their service is being stopped. A pending
+// stop of the service is received from stop.
+func stopExpected(target string, stop chan bool) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+	}
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l := lifecycle(target)
+	expected := l.expectedStop
+	l.expectedStop = false
+	return expected
+}
+
+// stopped reports whether a build failed because its service was stopped
+func stopped(err error) bool {
+	if err == docker.ErrBuildStopped {
+		return true
+	}
+	result, ok := err.(*multierror.Error)
+	return ok && len(result.Errors) > 0 && result.Errors[0] == docker.ErrBuildStopped
+}
+
+// waitIfFailed blocks until stop if a target failed too many times to be
+// retried, returning whether it did
+func waitIfFailed(target string, stop chan bool, l logging.Logger) bool {
+	if TargetLifecycle(target).State != Failed {
+		return false
+	}
+	l.Error(fmt.Sprintf("Target failed %d times in a row, not retrying", config.Get().MaxFailures))
+	<-stop
+	return true
+}
@@ -0,0 +1,119 @@
+// +build unit
+
+package supervisor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/docker"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	c := config.Default()
+	c.MaxFailures = 2
+	config.Set(c)
+	TrackLifecycle("target", nil)
+	assert.Equal(t, Registered, TargetLifecycle("target").State)
+
+	setLifecycleState("target", Building)
+	lifecycleFailed("target", fmt.Errorf("Error running build files"))
+	l := TargetLifecycle("target")
+	assert.Equal(t, Backoff, l.State)
+	assert.Equal(t, 1, l.Failures)
+	assert.Equal(t, "Error running build files", l.LastError)
+
+	// Failures only add up until the target starts fuzzing
+	setLifecycleState("target", Fuzzing)
+	assert.True(t, swapLifecycleState("target", Fuzzing, BackingUp))
+	assert.False(t, swapLifecycleState("target", Fuzzing, BackingUp))
+	lifecycleFailed("target", fmt.Errorf("Fuzzer ran out of memory"))
+	assert.Equal(t, Backoff, TargetLifecycle("target").State)
+	lifecycleFailed("target", fmt.Errorf("Fuzzer ran out of memory"))
+	l = TargetLifecycle("target")
+	assert.Equal(t, Failed, l.State)
+	assert.Equal(t, 3, l.Failures)
+
+	ForgetLifecycle("target")
+	assert.Equal(t, Registered, TargetLifecycle("target").State)
+}
+
+func TestRestoreLifecycle(t *testing.T) {
+	persisted := map[string]Lifecycle{}
+	PersistLifecycles(func(target string, l Lifecycle) { persisted[target] = l })
+	defer PersistLifecycles(func(string, Lifecycle) {})
+
+	TrackLifecycle("target", nil)
+	lifecycleFailed("target", fmt.Errorf("Error running build files"))
+	assert.Equal(t, 1, persisted["target"].ConsecutiveFailures)
+
+	// Failed targets stay failed, others start over
+	restored := persisted["target"]
+	restored.State = Failed
+	TrackLifecycle("target", &restored)
+	l := TargetLifecycle("target")
+	assert.Equal(t, Failed, l.State)
+	assert.Equal(t, 1, l.Failures)
+	assert.Equal(t, "Error running build files", l.LastError)
+	restored.State = Backoff
+	TrackLifecycle("target", &restored)
+	assert.Equal(t, Registered, TargetLifecycle("target").State)
+
+	setLifecycleState("target", Fuzzing)
+	assert.Equal(t, 0, persisted["target"].ConsecutiveFailures)
+	assert.Equal(t, 1, persisted["target"].Failures)
+	ForgetLifecycle("target")
+}
+
+func TestUnscheduled(t *testing.T) {
+	l := Lifecycle{State: Registered}
+	l.Unscheduled(false)
+	assert.Equal(t, Queued, l.State)
+	l.Unscheduled(true)
+	assert.Equal(t, Paused, l.State)
+
+	l = Lifecycle{State: Failed}
+	l.Unscheduled(true)
+	assert.Equal(t, Failed, l.State)
+}
+
+func TestStopExpected(t *testing.T) {
+	TrackLifecycle("target", nil)
+	stop := make(chan bool, 1)
+	assert.False(t, stopExpected("target", stop))
+
+	expectStop("target")
+	assert.True(t, stopExpected("target", stop))
+	assert.False(t, stopExpected("target", stop))
+
+	// A stop of the service is received
+	stop <- true
+	assert.True(t, stopExpected("target", stop))
+	assert.Len(t, stop, 0)
+	ForgetLifecycle("target")
+}
+
+func TestStopped(t *testing.T) {
+	assert.True(t, stopped(multierror.Append(nil, docker.ErrBuildStopped, fmt.Errorf("No such container"))))
+	assert.False(t, stopped(docker.ErrOOMKilled))
+}
+
+func TestPauseTarget(t *testing.T) {
+	TrackLifecycle("target", nil)
+	assert.False(t, ResumeTarget("target"))
+	assert.True(t, PauseTarget("target"))
+	assert.False(t, PauseTarget("target"))
+	assert.True(t, TargetPaused("target"))
+
+	// Registering a target again resumes it
+	TrackLifecycle("target", nil)
+	assert.False(t, TargetPaused("target"))
+	assert.True(t, PauseTarget("target"))
+	assert.True(t, ResumeTarget("target"))
+	assert.False(t, TargetPaused("target"))
+	ForgetLifecycle("target")
+}
@@ -0,0 +1,112 @@
+package supervisor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/docker"
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/metrics"
+	"github.com/everestmz/maxfuzz/internal/storage"
+)
+
+// PauseTarget asks the fuzzer service of a target to freeze its fuzzers in
+// place until it is resumed, returning false if it was already paused.
+// Targets started while paused wait to be resumed before setting up.
+func PauseTarget(target string) bool {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l := lifecycle(target)
+	if l.paused {
+		return false
+	}
+	l.paused = true
+	return true
+}
+
+// ResumeTarget lets the fuzzers of a paused target carry on where they were
+// frozen, returning false if it wasn't paused
+func ResumeTarget(target string) bool {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l, ok := lifecycles[target]
+	if !ok || !l.paused {
+		return false
+	}
+	l.paused = false
+	return true
+}
+
+func TargetPaused(target string) bool {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	l, ok := lifecycles[target]
+	return ok && l.paused
+}
+
+// waitIfPaused blocks while a target is paused, returning whether its service
+// was stopped meanwhile
+func waitIfPaused(target string, stop chan bool, l logging.Logger) bool {
+	if !TargetPaused(target) {
+		return false
+	}
+	setLifecycleState(target, Paused)
+	l.Info("Target paused, waiting to be resumed")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return true
+		case <-ticker.C:
+			if !TargetPaused(target) {
+				l.Info("Target resumed")
+				return false
+			}
+		}
+	}
+}
+
+// followPause freezes or thaws the running fuzzers of a target when it is
+// paused or resumed, returning whether they are frozen. Frozen fuzzers are
+// backed up straight away, so nothing is lost if they are stopped while
+// paused.
+func followPause(target string, frozen bool, h storage.StorageHandler, l logging.Logger) bool {
+	paused := TargetPaused(target)
+	if paused == frozen {
+		return frozen
+	}
+	if !paused {
+		err := docker.UnpauseFuzzers(target)
+		if err != nil {
+			l.Error(fmt.Sprintf("Could not resume fuzzers: %s", err.Error()))
+			return true
+		}
+		setLifecycleState(target, Fuzzing)
+		metrics.SetContainerState(target, metrics.Running)
+		l.Info("Fuzzers resumed")
+		return false
+	}
+
+	// Waits for backups in progress to finish, as the fuzzers are backed up
+	// once frozen
+	if !swapLifecycleState(target, Fuzzing, Paused) {
+		return false
+	}
+	err := docker.PauseFuzzers(target)
+	if err != nil {
+		docker.UnpauseFuzzers(target)
+		setLifecycleState(target, Fuzzing)
+		ResumeTarget(target)
+		l.Error(fmt.Sprintf("Could not pause fuzzers: %s", err.Error()))
+		return false
+	}
+	metrics.SetContainerState(target, metrics.Paused)
+	l.Info("Fuzzers paused, backing up")
+	err = backupTarget(target, h)
+	metrics.BackupFinished(target, err)
+	if err != nil {
+		l.Error(fmt.Sprintf("Could not back up paused fuzzers: %s", err.Error()))
+	}
+	return true
+}
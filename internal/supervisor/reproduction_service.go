@@ -0,0 +1,98 @@
+package supervisor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/everestmz/maxfuzz/internal/logging"
+	"github.com/everestmz/maxfuzz/internal/reproduction"
+	"github.com/everestmz/maxfuzz/internal/storage"
+	"github.com/everestmz/maxfuzz/internal/triage"
+)
+
+type ReproductionService struct {
+	logger  logging.Logger
+	stop    chan bool
+	target  string
+	queue   reproduction.Queue
+	buckets *triage.Buckets
+}
+
+func NewReproductionService(target string, l logging.Logger, queue reproduction.Queue, buckets *triage.Buckets) ReproductionService {
+	return ReproductionService{
+		logger:  l,
+		stop:    make(chan bool),
+		target:  target,
+		queue:   queue,
+		buckets: buckets,
+	}
+}
+
+func (s ReproductionService) Stop() {
+	s.logger.Info("ReproductionService stopping")
+	s.stop <- true
+}
+
+func (s ReproductionService) Serve() {
+	s.logger.Info("ReproductionService starting")
+	storageHandler, err := storage.Init(s.target)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Could not initialize storage client:\n%s", err.Error()))
+		return
+	}
+
+	s.logger.Info("ReproductionService waiting for crashes")
+	s.queue.Consume(s.stop, func(c reproduction.Crash) {
+		s.logger.Info(fmt.Sprintf("ReproductionService reproducing %s", c.PayloadID))
+		result, err := reproduction.Reproduce(c)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("ReproductionService could not reproduce %s: %s", c.PayloadID, err.Error()))
+			// Still bucket the crash, based on its file name
+			classification := triage.Classify([]string{}, 0, false, c.Filename)
+			bucket := bucketCrash(s.buckets, s.logger, []string{}, c.Filename, c.PayloadID, classification.Category)
+			s.setReproduction(storageHandler, c.PayloadID, storage.ReproductionFailed, classification.Category, bucket)
+			return
+		}
+
+		// Sanitizers and the Go runtime report on stderr
+		stdout := splitLines(result.Stdout)
+		stderr := splitLines(result.Stderr)
+		combined := append(append([]string{}, stderr...), stdout...)
+		classification := triage.Classify(combined, result.ExitCode, result.TimedOut, c.Filename)
+
+		s.logger.Info(fmt.Sprintf("ReproductionService classified %s as %s", c.PayloadID, classification.Category))
+		bucket := bucketCrash(s.buckets, s.logger, combined, c.Filename, c.PayloadID, classification.Category)
+
+		output := storage.FuzzerPayloadOutput{
+			Identifier: c.PayloadID,
+			Output:     stdout,
+			Stderr:     stderr,
+			ExitCode:   result.ExitCode,
+			TimedOut:   result.TimedOut,
+			Category:   classification.Category,
+			Signal:     classification.Signal,
+			Revision:   c.Revision,
+			Bucket:     bucket,
+		}
+		err = storageHandler.SaveOutput(output)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("ReproductionService could not save output for %s: %s", c.PayloadID, err.Error()))
+		}
+		s.setReproduction(storageHandler, c.PayloadID, storage.Reproduced, classification.Category, bucket)
+	})
+}
+
+func (s ReproductionService) setReproduction(h storage.StorageHandler, payloadID, state, category, bucket string) {
+	err := storage.SetReproduction(h, s.target, payloadID, state, category, bucket)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("ReproductionService could not update metadata of %s: %s", payloadID, err.Error()))
+	}
+}
+
+func splitLines(b []byte) []string {
+	trimmed := strings.TrimRight(string(b), "\n")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "\n")
+}
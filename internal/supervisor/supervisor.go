@@ -3,6 +3,7 @@ package supervisor
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/everestmz/maxfuzz/internal/logging"
@@ -32,7 +33,39 @@ func New(l logging.Logger, name string) *suture.Supervisor {
 type TargetStats struct {
 	ID             string  `json:"id"`
 	TestsPerSecond float64 `json:"tests_per_second"`
-	BugsFound      int     `json:"bugs_found"`
+	BugsFound      int     `json:"bugs_found"` // Unique crash buckets
+	Crashes        int     `json:"crashes"`    // Crashes reported by the fuzzer
+	TotalExecs     int64   `json:"total_execs"`
+	CorpusSize     int     `json:"corpus_size"`
+	Coverage       float64 `json:"coverage"`        // Bitmap coverage % for AFL, covered edges for libFuzzer and go-fuzz
+	Cycles         int     `json:"cycles"`          // Queue cycles completed, AFL only
+	Stability      float64 `json:"stability"`       // % of edges hit consistently, AFL only
+	SinceLastPath  int64   `json:"since_last_path"` // Seconds since the corpus last grew
+	Uptime         int64   `json:"uptime"`          // Seconds the fuzzer has been running
+	Engine         string  `json:"engine"`
+	OOMKills       int     `json:"oom_kills"` // Containers killed for exceeding their memory limit
+	Host           string  `json:"host"`      // Docker host the target was placed on
+
+	Lifecycle    Lifecycle          `json:"lifecycle"` // Including whether the target is queued by the scheduler
+	Minimization *MinimizationStats `json:"minimization,omitempty"`
+}
+
+// OOM kills of each target's containers since maxfuzz started, by target ID
+var oomKills = map[string]int{}
+var oomLock sync.Mutex
+
+func recordOOMKill(target string) {
+	oomLock.Lock()
+	defer oomLock.Unlock()
+	oomKills[target]++
+}
+
+// OOMKills counts the containers of a target killed for exceeding their
+// memory limit
+func OOMKills(target string) int {
+	oomLock.Lock()
+	defer oomLock.Unlock()
+	return oomKills[target]
 }
 
 // Log Writers
@@ -0,0 +1,104 @@
+package triage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/everestmz/maxfuzz/internal/storage"
+)
+
+// Buckets groups a target's crashes by signature. It is shared between the
+// services of a target, and persisted through the target's StorageHandler.
+type Buckets struct {
+	target  string
+	lock    sync.Mutex
+	loaded  bool
+	buckets map[string]*storage.CrashBucket
+	seen    map[string]string // Bucket signature of each payload ID already bucketed
+	handler storage.StorageHandler
+}
+
+func NewBuckets(target string) *Buckets {
+	return &Buckets{
+		target:  target,
+		buckets: map[string]*storage.CrashBucket{},
+		seen:    map[string]string{},
+	}
+}
+
+// load must be called with the lock held
+func (b *Buckets) load() error {
+	if b.loaded {
+		return nil
+	}
+	handler, err := storage.Init(b.target)
+	if err != nil {
+		return err
+	}
+	buckets, err := handler.GetBuckets()
+	if err != nil {
+		return fmt.Errorf("Could not load crash buckets: %s", err.Error())
+	}
+	b.handler = handler
+	b.buckets = buckets
+	for _, bucket := range buckets {
+		for _, payloadID := range bucket.Payloads {
+			b.seen[payloadID] = bucket.Signature
+		}
+	}
+	b.loaded = true
+	return nil
+}
+
+// Add records a crash in the bucket for its signature, returning the bucket
+// and whether the crash was the first in it. Adding a payload more than once
+// has no effect, and returns the bucket it was first added to even if its
+// signature changed, e.g. as its output was read while being written.
+func (b *Buckets) Add(sig Signature, category, payloadID string, size int64) (*storage.CrashBucket, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	err := b.load()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if hash, ok := b.seen[payloadID]; ok {
+		return b.buckets[hash], false, nil
+	}
+	bucket, exists := b.buckets[sig.Hash]
+	b.seen[payloadID] = sig.Hash
+
+	now := time.Now().Unix()
+	if !exists {
+		bucket = &storage.CrashBucket{
+			Signature:          sig.Hash,
+			Frames:             sig.Frames,
+			Category:           category,
+			FirstSeen:          now,
+			Representative:     payloadID,
+			RepresentativeSize: size,
+		}
+		b.buckets[sig.Hash] = bucket
+	}
+	bucket.Count++
+	bucket.Payloads = append(bucket.Payloads, payloadID)
+	bucket.LastSeen = now
+	if bucket.Category == "" || bucket.Category == Crash {
+		bucket.Category = category
+	}
+	if size < bucket.RepresentativeSize {
+		bucket.Representative = payloadID
+		bucket.RepresentativeSize = size
+	}
+
+	return bucket, !exists, b.handler.SaveBuckets(b.buckets)
+}
+
+// Count returns the number of unique buckets
+func (b *Buckets) Count() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.load()
+	return len(b.buckets)
+}
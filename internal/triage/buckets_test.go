@@ -0,0 +1,62 @@
+// +build unit
+
+package triage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/everestmz/maxfuzz/internal/config"
+	"github.com/everestmz/maxfuzz/internal/constants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketsSurviveRestart(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-buckets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	constants.LocalCrashStorage = tmp
+	config.Set(config.Default())
+
+	sig := Signature{Hash: "abc", Frames: []string{"parse"}}
+	bucket, first, err := NewBuckets("target").Add(sig, Crash, "1_crash", 10)
+	assert.Nil(t, err)
+	assert.True(t, first)
+	assert.Equal(t, 1, bucket.Count)
+
+	// Crashes already counted before a restart aren't counted again
+	b := NewBuckets("target")
+	bucket, first, err = b.Add(sig, Crash, "1_crash", 10)
+	assert.Nil(t, err)
+	assert.False(t, first)
+	assert.Equal(t, 1, bucket.Count)
+	bucket, _, err = b.Add(sig, Crash, "2_crash", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, bucket.Count)
+	assert.Equal(t, "2_crash", bucket.Representative)
+}
+
+func TestBucketsSignatureChange(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "maxfuzz-buckets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmp)
+	constants.LocalCrashStorage = tmp
+	config.Set(config.Default())
+
+	b := NewBuckets("target")
+	partial := Signature{Hash: "abc", Frames: []string{"parse"}}
+	_, first, err := b.Add(partial, Crash, "1_crash", 10)
+	assert.Nil(t, err)
+	assert.True(t, first)
+
+	// A payload parsed to another signature stays in its first bucket
+	full := Signature{Hash: "def", Frames: []string{"parse", "main"}}
+	bucket, first, err := b.Add(full, Crash, "1_crash", 10)
+	assert.Nil(t, err)
+	assert.False(t, first)
+	assert.Equal(t, "abc", bucket.Signature)
+	assert.Equal(t, 1, bucket.Count)
+	assert.Equal(t, 1, b.Count())
+}
@@ -0,0 +1,169 @@
+package triage
+
+// Classifies crashes by the kind of bug that caused them, from the output of
+// sanitizers, libFuzzer and the Go runtime.
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeapBufferOverflow    = "heap-buffer-overflow"
+	StackBufferOverflow   = "stack-buffer-overflow"
+	GlobalBufferOverflow  = "global-buffer-overflow"
+	UseAfterFree          = "use-after-free"
+	DoubleFree            = "double-free"
+	InvalidFree           = "invalid-free"
+	NullDeref             = "null-deref"
+	StackOverflow         = "stack-overflow"
+	OOM                   = "oom"
+	Timeout               = "timeout"
+	Leak                  = "leak"
+	UndefinedBehavior     = "undefined-behavior"
+	IndexOutOfRange       = "index-out-of-range"
+	SliceBoundsOutOfRange = "slice-bounds-out-of-range"
+	NilMapWrite           = "nil-map-write"
+	DivideByZero          = "divide-by-zero"
+	ConcurrentMapAccess   = "concurrent-map-access"
+	GoPanic               = "go-panic"
+	GoFatalError          = "go-fatal-error"
+	Segv                  = "segv"
+	Abort                 = "abort"
+	Crash                 = "crash"
+)
+
+type Classification struct {
+	Category string
+	Signal   int // 0 if the crash wasn't caused by a signal
+}
+
+// Ordered, so that more specific patterns win
+var outputPatterns = []struct {
+	pattern  string
+	category string
+}{
+	{"LeakSanitizer: detected memory leaks", Leak},
+	{"AddressSanitizer: heap-buffer-overflow", HeapBufferOverflow},
+	{"AddressSanitizer: stack-buffer-overflow", StackBufferOverflow},
+	{"AddressSanitizer: stack-buffer-underflow", StackBufferOverflow},
+	{"AddressSanitizer: global-buffer-overflow", GlobalBufferOverflow},
+	{"AddressSanitizer: heap-use-after-free", UseAfterFree},
+	{"AddressSanitizer: stack-use-after-return", UseAfterFree},
+	{"AddressSanitizer: stack-use-after-scope", UseAfterFree},
+	{"AddressSanitizer: attempting double-free", DoubleFree},
+	{"AddressSanitizer: attempting free on address which was not malloc", InvalidFree},
+	{"AddressSanitizer: stack-overflow", StackOverflow},
+	{"AddressSanitizer: allocation-size-too-big", OOM},
+	{"AddressSanitizer: requested allocation size", OOM},
+	{"AddressSanitizer: out of memory", OOM},
+	{"libFuzzer: out-of-memory", OOM},
+	{"libFuzzer: timeout", Timeout},
+	{"panic: runtime error: index out of range", IndexOutOfRange},
+	{"panic: runtime error: slice bounds out of range", SliceBoundsOutOfRange},
+	{"panic: assignment to entry in nil map", NilMapWrite},
+	{"panic: runtime error: invalid memory address or nil pointer dereference", NullDeref},
+	{"panic: runtime error: integer divide by zero", DivideByZero},
+	{"fatal error: concurrent map", ConcurrentMapAccess},
+	{"fatal error: runtime: out of memory", OOM},
+	{"fatal error: stack overflow", StackOverflow},
+	{"goroutine stack exceeds", StackOverflow},
+	{"panic: ", GoPanic},
+	{"fatal error: ", GoFatalError},
+	{"runtime error: ", UndefinedBehavior}, // UBSan
+}
+
+var signalCategories = map[int]string{
+	4:  "illegal-instruction",
+	6:  Abort,
+	7:  "bus-error",
+	8:  "fpe",
+	11: Segv,
+}
+
+// libFuzzer names its artifacts after the kind of crash
+var artifactCategories = map[string]string{
+	"leak-":    Leak,
+	"timeout-": Timeout,
+	"oom-":     OOM,
+}
+
+func artifactCategory(filename string) (string, bool) {
+	for prefix, category := range artifactCategories {
+		if strings.HasPrefix(filepath.Base(filename), prefix) {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// Matches ASAN's "SEGV on unknown address 0x000000000010"
+var segvAddress = regexp.MustCompile(`AddressSanitizer: SEGV on unknown address (0x[0-9a-fA-F]+)`)
+
+func classifySegv(line string) (string, bool) {
+	match := segvAddress.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	address, err := strconv.ParseUint(strings.TrimPrefix(match[1], "0x"), 16, 64)
+	if err == nil && address < 0x1000 {
+		return NullDeref, true
+	}
+	return Segv, true
+}
+
+// aflSignal reads the signal AFL encodes in crash file names
+func aflSignal(filename string) int {
+	for _, field := range strings.Split(filepath.Base(filename), ",") {
+		if strings.HasPrefix(field, "sig:") {
+			signal, err := strconv.Atoi(strings.TrimPrefix(field, "sig:"))
+			if err == nil {
+				return signal
+			}
+		}
+	}
+	return 0
+}
+
+// Classify works out what kind of crash produced output. exitCode is that of
+// the reproduction, where exit codes above 128 mean the process was killed by
+// a signal. filename is the crash file, which is used when the output doesn't
+// identify the crash.
+func Classify(output []string, exitCode int, timedOut bool, filename string) Classification {
+	toReturn := Classification{Signal: aflSignal(filename)}
+	if exitCode > 128 {
+		toReturn.Signal = exitCode - 128
+	}
+
+	for _, line := range output {
+		if category, ok := classifySegv(line); ok {
+			toReturn.Category = category
+			return toReturn
+		}
+	}
+	for _, p := range outputPatterns {
+		for _, line := range output {
+			if strings.Contains(line, p.pattern) {
+				toReturn.Category = p.category
+				return toReturn
+			}
+		}
+	}
+
+	if timedOut || filepath.Base(filepath.Dir(filename)) == "hangs" {
+		toReturn.Category = Timeout
+		return toReturn
+	}
+	if category, ok := artifactCategory(filename); ok {
+		toReturn.Category = category
+		return toReturn
+	}
+	if category, ok := signalCategories[toReturn.Signal]; ok {
+		toReturn.Category = category
+		return toReturn
+	}
+	toReturn.Category = Crash
+	return toReturn
+}
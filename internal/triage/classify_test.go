@@ -0,0 +1,55 @@
+// +build unit
+
+package triage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyOutput(t *testing.T) {
+	cases := []struct {
+		output   string
+		exitCode int
+		category string
+		signal   int
+	}{
+		{asanOutput, 134, HeapBufferOverflow, 6},
+		{goOutput, 2, IndexOutOfRange, 0},
+		{"==1==ERROR: AddressSanitizer: heap-use-after-free on address 0x6020", 134, UseAfterFree, 6},
+		{"==1==ERROR: AddressSanitizer: SEGV on unknown address 0x000000000008 (pc 0x4f3a2b)", 139, NullDeref, 11},
+		{"==1==ERROR: AddressSanitizer: SEGV on unknown address 0x7ffd1000 (pc 0x4f3a2b)", 139, Segv, 11},
+		{"==1==ERROR: LeakSanitizer: detected memory leaks", 1, Leak, 0},
+		{"==1== ERROR: libFuzzer: out-of-memory (malloc(2147483648))", 1, OOM, 0},
+		{"panic: assignment to entry in nil map", 2, NilMapWrite, 0},
+		{"panic: runtime error: invalid memory address or nil pointer dereference", 2, NullDeref, 0},
+		{"panic: unexpected EOF", 2, GoPanic, 0},
+		{"fatal error: concurrent map writes", 2, ConcurrentMapAccess, 0},
+		{"", 136, "fpe", 8},
+		{"", 1, Crash, 0},
+	}
+	for _, c := range cases {
+		result := Classify(strings.Split(c.output, "\n"), c.exitCode, false, "crashers/abc")
+		assert.Equal(t, c.category, result.Category, c.output)
+		assert.Equal(t, c.signal, result.Signal, c.output)
+	}
+}
+
+func TestClassifyFallback(t *testing.T) {
+	result := Classify([]string{}, 0, false, "/sync/target/crashes/id:000000,sig:11,src:000000,op:havoc,rep:2")
+	assert.Equal(t, Classification{Segv, 11}, result)
+
+	result = Classify([]string{}, 0, false, "/sync/target/hangs/id:000000,src:000000,op:havoc,rep:4")
+	assert.Equal(t, Classification{Timeout, 0}, result)
+
+	result = Classify([]string{}, 137, true, "/sync/target/crashes/id:000001,sig:06")
+	assert.Equal(t, Classification{Timeout, 9}, result)
+
+	result = Classify([]string{}, 0, false, "/sync/target/artifacts/oom-da39a3ee5e6b4b0d3255bfef95601890afd80709")
+	assert.Equal(t, Classification{OOM, 0}, result)
+
+	result = Classify([]string{}, 0, false, "/sync/target/artifacts/crash-da39a3ee5e6b4b0d3255bfef95601890afd80709")
+	assert.Equal(t, Classification{Crash, 0}, result)
+}
@@ -0,0 +1,157 @@
+package triage
+
+// Normalizes crash output into a signature, so that many crashing inputs
+// caused by the same bug end up in the same bucket.
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Number of frames from the top of the stack that make up a signature
+var signatureFrames = 3
+
+type Signature struct {
+	Hash   string
+	Frames []string
+}
+
+// Fallback is true when no stack trace could be found in the output
+func (s Signature) Fallback() bool {
+	return len(s.Frames) == 1 &&
+		(strings.HasPrefix(s.Frames[0], "afl:") || strings.HasPrefix(s.Frames[0], "libfuzzer:"))
+}
+
+// Matches both symbolized ("#0 0x4f3a2b in func file.c:10") and unsymbolized
+// ("#0 0x4f3a2b (/root/fuzzer/binary+0x4f3a2b)") sanitizer frames
+var sanitizerFrame = regexp.MustCompile(`^\s*#(\d+)\s+0x[0-9a-fA-F]+\s+(?:in\s+(\S+)|\((\S+)\))`)
+
+var goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+
+// Frames of the sanitizers, libc and the Go runtime are left out of
+// signatures. Namespaces are matched by prefix, and functions by their exact
+// name, so that user functions like abortTransaction are kept.
+type ignoredFrames struct {
+	prefixes []string
+	names    []string
+}
+
+var ignoredSanitizerFrames = ignoredFrames{
+	prefixes: []string{"__asan", "__interceptor", "__sanitizer", "__lsan", "__ubsan", "__msan", "__GI_", "libc.so"},
+	names:    []string{"__libc_start_main", "_start", "raise", "abort"},
+}
+
+var ignoredGoFrames = ignoredFrames{
+	prefixes: []string{"runtime.", "go-fuzz-dep."},
+	names:    []string{"panic"},
+}
+
+func ignored(frame string, i ignoredFrames) bool {
+	for _, p := range i.prefixes {
+		if strings.HasPrefix(frame, p) {
+			return true
+		}
+	}
+	for _, n := range i.names {
+		if frame == n {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizerFrames(output []string) []string {
+	frames := []string{}
+	started := false
+	for _, line := range output {
+		match := sanitizerFrame.FindStringSubmatch(line)
+		if match == nil {
+			if started {
+				// The first stack is the crashing one
+				break
+			}
+			continue
+		}
+		if started && match[1] == "0" {
+			break
+		}
+		started = true
+		frame := match[2]
+		if frame == "" {
+			// Unsymbolized, use the module and offset
+			frame = filepath.Base(match[3])
+		}
+		if !ignored(frame, ignoredSanitizerFrames) {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+func goFrames(output []string) []string {
+	frames := []string{}
+	started := false
+	for _, line := range output {
+		if !started {
+			started = goroutineHeader.MatchString(strings.TrimSpace(line))
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			// File and line of the previous frame
+			continue
+		}
+		frame := line
+		if i := strings.LastIndex(frame, "("); i > 0 {
+			frame = frame[:i]
+		}
+		if !ignored(frame, ignoredGoFrames) {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// aflFrames falls back to the fields AFL encodes in crash file names, e.g.
+// crashes/id:000000,sig:11,src:000000,op:havoc,rep:2, or to the kind of
+// libFuzzer artifact
+func aflFrames(filename string) []string {
+	if filepath.Base(filepath.Dir(filename)) == "hangs" {
+		return []string{"afl:hang"}
+	}
+	if category, ok := artifactCategory(filename); ok {
+		return []string{"libfuzzer:" + category}
+	}
+	for _, field := range strings.Split(filepath.Base(filename), ",") {
+		if strings.HasPrefix(field, "sig:") {
+			return []string{"afl:" + field}
+		}
+	}
+	return []string{"afl:unknown"}
+}
+
+// Parse builds a signature from the top frames of the first sanitizer or Go
+// stack trace in output, falling back to the crash file name
+func Parse(output []string, filename string) Signature {
+	frames := sanitizerFrames(output)
+	if len(frames) == 0 {
+		frames = goFrames(output)
+	}
+	if len(frames) == 0 {
+		frames = aflFrames(filename)
+	}
+	if len(frames) > signatureFrames {
+		frames = frames[:signatureFrames]
+	}
+
+	sum := sha1.Sum([]byte(strings.Join(frames, "\n")))
+	return Signature{
+		Hash:   hex.EncodeToString(sum[:])[:16],
+		Frames: frames,
+	}
+}
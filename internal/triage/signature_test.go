@@ -0,0 +1,74 @@
+// +build unit
+
+package triage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var asanOutput = `==1234==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000011
+READ of size 1 at 0x602000000011 thread T0
+    #0 0x4f3a2b in __asan_memcpy (/root/fuzzer/vulnerable+0x4f3a2b)
+    #1 0x4f3b1c in parse_header /root/fuzzer/vulnerable.c:10:5
+    #2 0x4f3c1d in parse /root/fuzzer/vulnerable.c:20:3
+    #3 0x4f3d1e in main /root/fuzzer/vulnerable.c:30:3
+    #4 0x7f0000000000 in __libc_start_main (/lib/x86_64-linux-gnu/libc.so.6+0x20830)
+
+0x602000000011 is located 0 bytes to the right of 1-byte region
+allocated by thread T0 here:
+    #0 0x4f0000 in malloc (/root/fuzzer/vulnerable+0x4f0000)`
+
+var goOutput = `panic: runtime error: index out of range
+
+goroutine 1 [running]:
+github.com/example/parser.readHeader(0xc42000e0a0, 0x5, 0x8, 0x0)
+	/root/go/src/github.com/example/parser/header.go:12 +0x1d
+github.com/example/parser.Parse(0xc42000e0a0, 0x5, 0x8)
+	/root/go/src/github.com/example/parser/parser.go:30 +0x4a
+github.com/example/parser.Fuzz(0x7f0000000000, 0x5, 0x5, 0x3)
+	/root/go/src/github.com/example/parser/fuzz.go:6 +0x2b
+go-fuzz-dep.Main(0x5214d0)
+	/root/go/src/go-fuzz-dep/main.go:49 +0xad
+main.main()
+	/tmp/go-fuzz-build/src/go.fuzz.main/main.go:10 +0x2d
+
+goroutine 2 [runnable]:`
+
+func TestParseSanitizerOutput(t *testing.T) {
+	sig := Parse(strings.Split(asanOutput, "\n"), "crashes/id:000000,sig:06")
+	assert.Equal(t, []string{"parse_header", "parse", "main"}, sig.Frames)
+	assert.False(t, sig.Fallback())
+	assert.Len(t, sig.Hash, 16)
+
+	// Only libc's abort is left out, not user functions named like it
+	sig = Parse([]string{
+		"    #0 0x7f0000000001 in raise (/lib/x86_64-linux-gnu/libc.so.6+0x35428)",
+		"    #1 0x7f0000000002 in abort (/lib/x86_64-linux-gnu/libc.so.6+0x3702a)",
+		"    #2 0x4f3b1c in abortTransaction /root/fuzzer/db.c:10:5",
+	}, "crashes/id:000001,sig:06")
+	assert.Equal(t, []string{"abortTransaction"}, sig.Frames)
+}
+
+func TestParseGoPanic(t *testing.T) {
+	sig := Parse(strings.Split(goOutput, "\n"), "crashers/abc")
+	assert.Equal(t, []string{
+		"github.com/example/parser.readHeader",
+		"github.com/example/parser.Parse",
+		"github.com/example/parser.Fuzz",
+	}, sig.Frames)
+}
+
+func TestParseFallback(t *testing.T) {
+	sig := Parse([]string{}, "/sync/target/crashes/id:000003,sig:11,src:000000,op:havoc,rep:2")
+	assert.Equal(t, []string{"afl:sig:11"}, sig.Frames)
+	assert.True(t, sig.Fallback())
+
+	other := Parse([]string{}, "/sync/target/crashes/id:000009,sig:11,src:000002,op:flip1,pos:3")
+	assert.Equal(t, sig.Hash, other.Hash)
+
+	hang := Parse([]string{}, "/sync/target/hangs/id:000000,src:000000,op:havoc,rep:4")
+	assert.Equal(t, []string{"afl:hang"}, hang.Frames)
+}
@@ -1,9 +1,26 @@
 package types
 
 type Target struct {
-	Name     string `json:"name"`
-	UniqueID string `json:"id"`
-	Language string `json:"language"`
-	Location string `json:"location"`
-	Revision string `json:"revision"`
+	Name      string    `json:"name"`
+	UniqueID  string    `json:"id"`
+	Language  string    `json:"language"`
+	Engine    string    `json:"engine"` // afl, libfuzzer or go-fuzz
+	Location  string    `json:"location"`
+	Revision  string    `json:"revision"`
+	Instances int       `json:"instances"` // Parallel fuzzer instances, AFL only
+	MinSlot   int       `json:"min_slot"`  // Round robin slot bounds in seconds, 0 for the configured default
+	MaxSlot   int       `json:"max_slot"`
+	Weight    int       `json:"weight"` // Share of fuzzing time under the weighted and hybrid strategies, 0 counts as 1
+	Cores     int       `json:"cores"`  // Cores reserved under the parallel strategy, 0 for one per instance
+	Resources Resources `json:"resources"`
+}
+
+// Resources limits each of a target's buildbox and fuzzer containers. Zero
+// values don't limit anything.
+type Resources struct {
+	CPUs      float64 `json:"cpus"`   // CPU quota, e.g. 1.5
+	Cpuset    string  `json:"cpuset"` // CPUs the containers may run on, e.g. "0-3". Only assigned by the parallel strategy.
+	Memory    int64   `json:"memory"` // Bytes, swap included
+	PidsLimit int64   `json:"pids_limit"`
+	TmpfsSize int64   `json:"tmpfs_size"` // Bytes of tmpfs mounted at /tmp, 0 for no tmpfs
 }
@@ -29,6 +29,14 @@ rm -rf /root/third_party
 cd /root/fuzzer
 `
 
+var libfuzzerBuildSteps = `
+#### libFuzzer Setup:
+#### Link the harness defining LLVMFuzzerTestOneInput with $LIBFUZZER_FLAGS, e.g.
+#### $CXX $CXXFLAGS $LIBFUZZER_FLAGS harness.cc -o $LIBFUZZER_BINARY
+#### and build the code under test with $CFLAGS/$CXXFLAGS
+cd /root/fuzzer
+`
+
 //
 // ENVIRONMENT SNIPPETS
 //
@@ -61,6 +69,17 @@ export AFL_MEMORY_LIMIT=%s
 export AFL_OPTIONS="%s"
 `
 
+var libfuzzerEnvironmentSettings = `
+export CC="%[1]s/third_party/llvm-build/Release+Asserts/bin/clang"
+export CXX="%[1]s/third_party/llvm-build/Release+Asserts/bin/clang++"
+export LIBFUZZER_FLAGS="-fsanitize=fuzzer%[2]s"
+export CFLAGS="-g -fsanitize=fuzzer-no-link%[2]s"
+export CXXFLAGS="-g -fsanitize=fuzzer-no-link%[2]s"
+export LIBFUZZER_BINARY=%[3]s
+export LIBFUZZER_RSS_LIMIT=%[4]s
+export LIBFUZZER_OPTIONS="%[5]s"
+`
+
 var goEnvironmentSettings = `
 export GO_FUZZ_ZIP=$BUILD_FILES/%s
 `
@@ -20,16 +20,20 @@ type Fuzzer interface {
 }
 
 // New returns a new Template struct
-func New(fuzzerName, language string, asan bool, base string) (Template, error) {
+func New(fuzzerName, language, engine string, asan bool, base string) (Template, error) {
 	if language == maxfuzz.Go {
 		asan = false
 	}
 	if !maxfuzz.SupportedBase(base) {
 		return Template{}, fmt.Errorf("base %s not supported", base)
 	}
+	if !maxfuzz.SupportedEngine(engine, language) {
+		return Template{}, fmt.Errorf("engine %s not supported for language %s", engine, language)
+	}
 	return Template{
 		FuzzerName: fuzzerName,
 		Language:   language,
+		Engine:     engine,
 		ASAN:       asan,
 		Base:       base,
 	}, nil
@@ -39,6 +43,7 @@ func New(fuzzerName, language string, asan bool, base string) (Template, error)
 type Template struct {
 	FuzzerName string
 	Language   string
+	Engine     string
 	ASAN       bool
 	Base       string
 }
@@ -55,6 +60,9 @@ func (t Template) GenerateBuildSteps(f Fuzzer) bytes.Buffer {
 		if t.ASAN {
 			buf.WriteString(asanBuildSteps)
 		}
+		if t.Engine == maxfuzz.LibFuzzer {
+			buf.WriteString(libfuzzerBuildSteps)
+		}
 		// Ensure we're running things from build files dir
 		buf.WriteString("cd $BUILD_FILES\n")
 		for _, line := range f.BuildSteps() {
@@ -77,10 +85,26 @@ func (t Template) GenerateEnvironment(f Fuzzer) bytes.Buffer {
 
 	buf.WriteString(fmt.Sprintf("export CORPUS=%s\n", f.Corpus()))
 
-	switch t.Language {
-	case maxfuzz.Go:
+	switch {
+	case t.Language == maxfuzz.Go:
 		buf.WriteString(fmt.Sprintf(goEnvironmentSettings, f.Run()))
-	case maxfuzz.Python:
+	case t.Engine == maxfuzz.LibFuzzer:
+		// The ASAN build steps move clang out of /root
+		clangRoot, sanitizers := "/root", ""
+		if t.ASAN {
+			clangRoot, sanitizers = "/usr/local/bin", ",address"
+		}
+		buf.WriteString(
+			fmt.Sprintf(
+				libfuzzerEnvironmentSettings,
+				clangRoot,
+				sanitizers,
+				f.Run(),
+				f.MemoryLimit(),
+				f.Options(),
+			),
+		)
+	case t.Language == maxfuzz.Python:
 		buf.WriteString(
 			fmt.Sprintf(
 				pythonEnvironmentSettings,
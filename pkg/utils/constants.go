@@ -14,3 +14,12 @@ const CPP = "c++"
 
 // Go Language constant
 const Go = "go"
+
+// AFL Engine constant
+const AFL = "afl"
+
+// LibFuzzer Engine constant
+const LibFuzzer = "libfuzzer"
+
+// GoFuzz Engine constant
+const GoFuzz = "go-fuzz"
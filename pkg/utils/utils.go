@@ -9,6 +9,13 @@ var supportedLanguages = map[string]bool{
 	Python: true,
 }
 
+// Engines, and the languages they can fuzz
+var supportedEngines = map[string]map[string]bool{
+	AFL:       {C: true, CPP: true, Ruby: true, Python: true},
+	LibFuzzer: {C: true, CPP: true},
+	GoFuzz:    {Go: true},
+}
+
 // SupportedBase returns true if the base is supported by Maxfuzz
 func SupportedBase(base string) bool {
 	_, ok := supportedBases[base]
@@ -20,3 +27,17 @@ func SupportedLanguage(language string) bool {
 	_, ok := supportedLanguages[language]
 	return ok
 }
+
+// SupportedEngine returns true if the engine can fuzz targets in language
+func SupportedEngine(engine, language string) bool {
+	languages, ok := supportedEngines[engine]
+	return ok && languages[language]
+}
+
+// DefaultEngine returns the engine used for a language when none is given
+func DefaultEngine(language string) string {
+	if language == Go {
+		return GoFuzz
+	}
+	return AFL
+}